@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	goflags "github.com/jessevdk/go-flags"
+	"github.com/raminhz90/esm/migrate"
+)
+
+// options of the commands by long name, every command accepts the common ones
+var (
+	commonOptions  = []string{"config", "log", "http_listen", "report", "parallel_jobs"}
+	sourceOptions  = []string{"source", "source_auth", "source_proxy", "src_indexes", "all", "query", "compress", "green"}
+	readOptions    = []string{"count", "time", "sliced_scroll_size", "split", "split_field", "split_count", "readers", "scroll_retries", "fields", "buffer_count", "buffer_mb", "checkpoint"}
+	targetOptions  = []string{"dest", "dest_auth", "dest_proxy", "dest_index", "green"}
+	writeOptions   = []string{"workers", "bulk_size", "type_override", "rename", "regenerate_id", "repeat_times", "sleep", "adaptive", "max_bulk_size", "bulk_latency", "queue_threshold", "bulk_retries", "max_docs_per_sec", "max_bytes_per_sec", "refresh", "logstash_endpoint", "secured_logstash_endpoint", "buffer_count", "buffer_mb", "checkpoint"}
+	indexOptions   = []string{"force", "yes", "protected_indices", "backup", "snapshot_repository", "copy_settings", "copy_mappings", "shards", "index_settings", "green_timeout", "dry_run", "plan_format", "plan_file"}
+	cutoverOptions = []string{"alias", "verify", "old_index_action"}
+	modeOptions    = []string{"mode", "snapshot_path", "rename_pattern", "rename_replacement", "remote_host"}
+	kafkaOptions   = []string{"kafka_brokers", "kafka_group", "kafka_batch_size", "kafka_batch_timeout", "kafka_compression", "kafka_idle_timeout"}
+	s3Options      = []string{"s3_endpoint", "s3_region", "s3_auth", "s3_chunk_size", "s3_part_size", "s3_concurrency"}
+)
+
+// command is a subcommand of esm with its own options and required ones, of
+// alternatives separated by | one is required. prepare sets the options
+// implied by the command
+type command struct {
+	name        string
+	description string
+	options     [][]string
+	required    []string
+	prepare     func(c *migrate.Config)
+}
+
+var commands = []*command{
+	{
+		name:        "copy",
+		description: "copy documents from source to target cluster, the default without a command",
+		options:     [][]string{sourceOptions, readOptions, targetOptions, writeOptions, indexOptions, cutoverOptions, modeOptions},
+		required:    []string{"source", "dest"},
+	},
+	{
+		name:        "dump",
+		description: "dump documents of source cluster into a file or kafka",
+		options:     [][]string{sourceOptions, readOptions, {"output_file", "output_format", "output_topic"}, kafkaOptions, s3Options},
+		required:    []string{"source", "output_file|output_topic"},
+	},
+	{
+		name:        "load",
+		description: "load documents of a file or kafka into target cluster",
+		options:     [][]string{{"input_file", "input_file_type", "input_topic", "readers"}, kafkaOptions, s3Options, targetOptions, writeOptions, indexOptions, {"alias", "old_index_action"}},
+		required:    []string{"input_file|input_topic", "dest"},
+	},
+	{
+		name:        "verify",
+		description: "compare document counts of source and target indexes",
+		options:     [][]string{sourceOptions, targetOptions, {"regenerate_id", "repeat_times"}},
+		required:    []string{"source", "dest"},
+		prepare: func(c *migrate.Config) {
+			c.Verify = true
+			c.SkipDocuments = true
+		},
+	},
+	{
+		name:        "mappings",
+		description: "create target indexes with settings and mappings of source, without documents",
+		options:     [][]string{sourceOptions, targetOptions, indexOptions},
+		required:    []string{"source", "dest"},
+		prepare: func(c *migrate.Config) {
+			c.CopyIndexSettings = true
+			c.CopyIndexMappings = true
+			c.SkipDocuments = true
+		},
+	},
+	{
+		name:        "inspect",
+		description: "print version, health, indexes, sizes and mappings of source cluster",
+		options:     [][]string{{"source", "source_auth", "source_proxy", "src_indexes", "all"}},
+		required:    []string{"source"},
+	},
+}
+
+// findCommand returns the command named by the first arg, nil for the old
+// form without a command
+func findCommand(args []string) *command {
+	if len(args) == 0 {
+		return nil
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// accepts tells if the option is one of the command
+func (cmd *command) accepts(name string) bool {
+	for _, option := range commonOptions {
+		if option == name {
+			return true
+		}
+	}
+	for _, group := range cmd.options {
+		for _, option := range group {
+			if option == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newParser returns the parser of the config, the options of other commands
+// are hidden from the help of a command
+func newParser(cmd *command, c *migrate.Config) *goflags.Parser {
+	parser := goflags.NewParser(c, goflags.Default)
+	if cmd == nil {
+		names := []string{}
+		for _, cmd := range commands {
+			names = append(names, cmd.name)
+		}
+		parser.Usage = fmt.Sprintf("[OPTIONS]\n  esm [%s] [OPTIONS]", strings.Join(names, "|"))
+		return parser
+	}
+	parser.Name = "esm " + cmd.name
+	parser.Usage = "[OPTIONS]\n\n" + cmd.description
+	options := map[string]*goflags.Option{}
+	collectOptions(parser.Command.Group, options)
+	for name, option := range options {
+		option.Hidden = !cmd.accepts(name)
+	}
+	return parser
+}
+
+// parseConfig parses the args of a job, the options of other commands are
+// rejected and the required ones checked
+func parseConfig(cmd *command, args []string) (*migrate.Config, error) {
+	c := &migrate.Config{}
+	parser := newParser(cmd, c)
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+	if cmd == nil {
+		return c, nil
+	}
+
+	options := map[string]*goflags.Option{}
+	collectOptions(parser.Command.Group, options)
+	for _, name := range sortedOptionNames(options) {
+		if given(options[name]) && !cmd.accepts(name) {
+			return nil, fmt.Errorf("--%s is not an option of esm %s", name, cmd.name)
+		}
+	}
+	for _, required := range cmd.required {
+		names := strings.Split(required, "|")
+		found := false
+		for _, name := range names {
+			found = found || given(options[name])
+		}
+		if !found {
+			return nil, fmt.Errorf("esm %s needs --%s", cmd.name, strings.Join(names, " or --"))
+		}
+	}
+
+	if cmd.prepare != nil {
+		cmd.prepare(c)
+	}
+	return c, nil
+}
+
+// given tells if the option was set by the args, not by its default
+func given(option *goflags.Option) bool {
+	return option.IsSet() && !option.IsSetDefault()
+}
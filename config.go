@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	goflags "github.com/jessevdk/go-flags"
+	"github.com/raminhz90/esm/migrate"
+	"gopkg.in/yaml.v3"
+)
+
+const envPrefix = "ESM_"
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// loadJobArgs merges the config file, ESM_* environment variables and the
+// command line into one argument list per job. Options are rendered as
+// command line arguments in order of precedence, so that go-flags keeps the
+// last one: config file, job in the config file, environment, command line.
+func loadJobArgs(args []string) ([][]string, error) {
+	configFile, err := findConfigFile(args)
+	if err != nil {
+		return nil, err
+	}
+
+	options := map[string]*goflags.Option{}
+	parser := goflags.NewParser(&migrate.Config{}, goflags.None)
+	collectOptions(parser.Command.Group, options)
+
+	envArgs := []string{}
+	for _, name := range sortedOptionNames(options) {
+		if value, ok := os.LookupEnv(envPrefix + strings.ToUpper(name)); ok {
+			envArgs = append(envArgs, optionArgs(options[name], value)...)
+		}
+	}
+
+	if len(configFile) == 0 {
+		return [][]string{resolveBoolArgs(options, append(envArgs, args...))}, nil
+	}
+
+	settings, jobs, err := readConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	baseArgs, err := settingsArgs(options, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(jobs) == 0 {
+		jobs = []map[string]interface{}{{}}
+	}
+
+	jobArgs := [][]string{}
+	for i, job := range jobs {
+		delete(job, "name")
+		overrides, err := settingsArgs(options, job)
+		if err != nil {
+			return nil, fmt.Errorf("job %d: %v", i+1, err)
+		}
+		merged := append([]string{}, baseArgs...)
+		merged = append(merged, overrides...)
+		merged = append(merged, envArgs...)
+		merged = append(merged, args...)
+		jobArgs = append(jobArgs, resolveBoolArgs(options, merged))
+	}
+	return jobArgs, nil
+}
+
+// findConfigFile looks for --config in the command line args, falling back
+// to ESM_CONFIG
+func findConfigFile(args []string) (string, error) {
+	opts := struct {
+		ConfigFile string `long:"config"`
+	}{}
+	parser := goflags.NewParser(&opts, goflags.IgnoreUnknown)
+	if _, err := parser.ParseArgs(args); err != nil {
+		return "", err
+	}
+	if len(opts.ConfigFile) == 0 {
+		opts.ConfigFile = os.Getenv(envPrefix + "CONFIG")
+	}
+	return opts.ConfigFile, nil
+}
+
+// readConfigFile loads a yaml or toml file, ${ENV} references in its string
+// values are replaced after parsing, the jobs list is returned separately
+func readConfigFile(path string) (map[string]interface{}, []map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	settings := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		_, err = toml.Decode(string(data), &settings)
+	default:
+		err = yaml.Unmarshal(data, &settings)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	missing := map[string]bool{}
+	interpolateEnv(settings, missing)
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, nil, fmt.Errorf("environment variables referenced in %s are not set: %s", path, strings.Join(names, ", "))
+	}
+
+	jobs := []map[string]interface{}{}
+	if v, ok := settings["jobs"]; ok {
+		delete(settings, "jobs")
+		list, ok := v.([]interface{})
+		if !ok {
+			if tables, ok := v.([]map[string]interface{}); ok {
+				jobs = tables
+			} else {
+				return nil, nil, errors.New("jobs must be a list")
+			}
+		}
+		for _, item := range list {
+			job, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, nil, errors.New("every job must be a map of options")
+			}
+			jobs = append(jobs, job)
+		}
+	}
+	return settings, jobs, nil
+}
+
+// interpolateEnv replaces ${ENV} references in the strings of a parsed
+// value in place, the names of unset variables are added to missing
+func interpolateEnv(value interface{}, missing map[string]bool) interface{} {
+	switch v := value.(type) {
+	case string:
+		return envPattern.ReplaceAllStringFunc(v, func(ref string) string {
+			name := envPattern.FindStringSubmatch(ref)[1]
+			env, ok := os.LookupEnv(name)
+			if !ok {
+				missing[name] = true
+			}
+			return env
+		})
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = interpolateEnv(item, missing)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = interpolateEnv(item, missing)
+		}
+	case []map[string]interface{}:
+		for _, item := range v {
+			interpolateEnv(item, missing)
+		}
+	}
+	return value
+}
+
+// settingsArgs renders config file settings as command line args
+func settingsArgs(options map[string]*goflags.Option, settings map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := []string{}
+	for _, key := range keys {
+		option, ok := options[key]
+		if !ok || key == "config" {
+			return nil, fmt.Errorf("unknown option in config file: %s", key)
+		}
+		value := fmt.Sprint(settings[key])
+		switch settings[key].(type) {
+		case map[string]interface{}, []interface{}:
+			// structured values like index_settings are passed as json
+			data, err := json.Marshal(settings[key])
+			if err != nil {
+				return nil, err
+			}
+			value = string(data)
+		}
+		args = append(args, optionArgs(option, value)...)
+	}
+	return args, nil
+}
+
+// collectOptions indexes the options of a group and its sub groups by long name
+func collectOptions(group *goflags.Group, options map[string]*goflags.Option) {
+	for _, option := range group.Options() {
+		if len(option.LongName) > 0 {
+			options[option.LongName] = option
+		}
+	}
+	for _, g := range group.Groups() {
+		collectOptions(g, options)
+	}
+}
+
+// optionArgs renders one option, bool options are flags without value, a
+// false one is --name=false and turns off the flag given before it
+func optionArgs(option *goflags.Option, value string) []string {
+	if option.Field().Type.Kind() == reflect.Bool {
+		if strings.EqualFold(value, "true") || value == "1" {
+			return []string{"--" + option.LongName}
+		}
+		if enabled, err := strconv.ParseBool(value); err == nil && !enabled {
+			return []string{"--" + option.LongName + "=false"}
+		}
+		return nil
+	}
+	return []string{"--" + option.LongName + "=" + value}
+}
+
+// resolveBoolArgs drops the flags turned off by a later --name=false, long
+// or short, go-flags doesn't take a value for bool options
+func resolveBoolArgs(options map[string]*goflags.Option, args []string) []string {
+	resolved := []string{}
+	for _, arg := range args {
+		flag, off := strings.CutSuffix(arg, "=false")
+		if option, ok := options[strings.TrimPrefix(flag, "--")]; off && strings.HasPrefix(flag, "--") && ok && option.Field().Type.Kind() == reflect.Bool {
+			short := flag
+			if option.ShortName != 0 {
+				short = "-" + string(option.ShortName)
+			}
+			kept := []string{}
+			for _, earlier := range resolved {
+				if earlier != flag && earlier != short {
+					kept = append(kept, earlier)
+				}
+			}
+			resolved = kept
+			continue
+		}
+		resolved = append(resolved, arg)
+	}
+	return resolved
+}
+
+func sortedOptionNames(options map[string]*goflags.Option) []string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	goflags "github.com/jessevdk/go-flags"
+	"github.com/raminhz90/esm/migrate"
+)
+
+func configOptions() map[string]*goflags.Option {
+	options := map[string]*goflags.Option{}
+	collectOptions(goflags.NewParser(&migrate.Config{}, goflags.None).Command.Group, options)
+	return options
+}
+
+func TestResolveBoolArgs(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"--force", "--force=false"}, []string{}},
+		{[]string{"-f", "-a", "--force=false"}, []string{"-a"}},
+		{[]string{"--force=false", "-f"}, []string{"-f"}},
+		{[]string{"-x", "", "--all=false"}, []string{"-x", ""}},
+		{[]string{"--source=false"}, []string{"--source=false"}},
+	}
+	options := configOptions()
+	for _, test := range tests {
+		if resolved := resolveBoolArgs(options, test.args); !reflect.DeepEqual(resolved, test.expected) {
+			t.Errorf("%q resolved to %q, expected %q", test.args, resolved, test.expected)
+		}
+	}
+}
+
+func TestReadConfigFileEnv(t *testing.T) {
+	// yaml and toml special characters of the secret are kept as is
+	t.Setenv("ESM_TEST_AUTH", `elastic:p#ss"word: {x}`)
+	for name, content := range map[string]string{
+		"config.yml":  "source_auth: ${ESM_TEST_AUTH}\njobs:\n  - dest_auth: \"${ESM_TEST_AUTH}\"\n",
+		"config.toml": "source_auth = \"${ESM_TEST_AUTH}\"\n[[jobs]]\ndest_auth = \"${ESM_TEST_AUTH}\"\n",
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		settings, jobs, err := readConfigFile(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if settings["source_auth"] != `elastic:p#ss"word: {x}` || len(jobs) != 1 || jobs[0]["dest_auth"] != `elastic:p#ss"word: {x}` {
+			t.Errorf("%s: settings %v and jobs %v, expected the secret as is", name, settings, jobs)
+		}
+	}
+}
+
+func TestReadConfigFileMissingEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte("source_auth: ${ESM_TEST_MISSING}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readConfigFile(path); err == nil {
+		t.Error("config file of an unset variable was read")
+	}
+}
@@ -0,0 +1,865 @@
+// Package esmtest is an in-memory elasticsearch served over http, it answers
+// the requests esm sends with the response shapes of the emulated version.
+//
+//	server := esmtest.NewServer("6.8.0")
+//	defer server.Close()
+//	server.AddDocs("orders", 1000)
+//	// esm -s server.URL ...
+package esmtest
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Doc is a stored document
+type Doc struct {
+	Type    string
+	Routing string
+	Source  json.RawMessage
+}
+
+// Index is a stored index, documents keep their insertion order
+type Index struct {
+	Settings map[string]interface{}
+	Mappings map[string]interface{}
+	Aliases  map[string]interface{}
+	Docs     map[string]*Doc
+	ids      []string
+}
+
+func newIndex() *Index {
+	return &Index{
+		Settings: map[string]interface{}{"number_of_shards": "1", "number_of_replicas": "1"},
+		Mappings: map[string]interface{}{},
+		Aliases:  map[string]interface{}{},
+		Docs:     map[string]*Doc{},
+	}
+}
+
+func (idx *Index) put(id string, doc *Doc) {
+	if _, ok := idx.Docs[id]; !ok {
+		idx.ids = append(idx.ids, id)
+	}
+	idx.Docs[id] = doc
+}
+
+func (idx *Index) remove(id string) {
+	if _, ok := idx.Docs[id]; !ok {
+		return
+	}
+	delete(idx.Docs, id)
+	for i, existing := range idx.ids {
+		if existing == id {
+			idx.ids = append(idx.ids[:i], idx.ids[i+1:]...)
+			break
+		}
+	}
+}
+
+// failure fails the requests of an endpoint once after of them passed
+type failure struct {
+	after  int
+	status int
+}
+
+type scroll struct {
+	hits []map[string]interface{}
+	pos  int
+	size int
+}
+
+// Server emulates one node of the given version, 1.x to 9.x
+type Server struct {
+	*httptest.Server
+	Version string
+
+	lock     sync.Mutex
+	indices  map[string]*Index
+	scrolls  map[string]*scroll
+	scrollId int
+	autoId   int
+	failures map[string]*failure
+	delay    time.Duration
+}
+
+func NewServer(version string) *Server {
+	s := &Server{
+		Version:  version,
+		indices:  map[string]*Index{},
+		scrolls:  map[string]*scroll{},
+		failures: map[string]*failure{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) major() int {
+	n, _ := strconv.Atoi(strings.SplitN(s.Version, ".", 2)[0])
+	return n
+}
+
+// docType is the type of new documents, there are no types from 8.x on
+func (s *Server) docType() string {
+	switch {
+	case s.major() >= 8:
+		return ""
+	case s.major() >= 6:
+		return "_doc"
+	default:
+		return "doc"
+	}
+}
+
+// AddDocs creates the index if needed and adds count documents with the ids
+// 0 to count-1
+func (s *Server) AddDocs(index string, count int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	idx, ok := s.indices[index]
+	if !ok {
+		idx = newIndex()
+		s.indices[index] = idx
+	}
+	for i := 0; i < count; i++ {
+		source, _ := json.Marshal(map[string]interface{}{"n": i, "name": fmt.Sprintf("doc %d", i)})
+		idx.put(strconv.Itoa(i), &Doc{Type: s.docType(), Source: source})
+	}
+}
+
+// Fail answers the requests of the endpoint with the status once after of
+// them were answered, ie: _bulk or _search/scroll. A status of 0 stops
+// failing.
+func (s *Server) Fail(endpoint string, after int, status int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if status == 0 {
+		delete(s.failures, endpoint)
+		return
+	}
+	s.failures[endpoint] = &failure{after: after, status: status}
+}
+
+// Delay delays every answer, like a busy node
+func (s *Server) Delay(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.delay = d
+}
+
+// failed tells if the request is to fail, the lock is held
+func (s *Server) failed(w http.ResponseWriter, r *http.Request) bool {
+	for endpoint, f := range s.failures {
+		if !strings.HasSuffix(r.URL.Path, endpoint) {
+			continue
+		}
+		if f.after > 0 {
+			f.after--
+			return false
+		}
+		s.sendError(w, f.status, "esmtest_exception", "failure of "+r.Method+" "+r.URL.Path)
+		return true
+	}
+	return false
+}
+
+// Index returns the stored index, nil if it doesn't exist
+func (s *Server) Index(name string) *Index {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.indices[name]
+}
+
+// Count returns the documents of the index
+func (s *Server) Count(index string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if idx, ok := s.indices[index]; ok {
+		return len(idx.Docs)
+	}
+	return 0
+}
+
+// Aliases returns the indexes the alias points to
+func (s *Server) Aliases(alias string) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := []string{}
+	for name, idx := range s.indices {
+		if _, ok := idx.Aliases[alias]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve expands comma separated names, aliases, wildcards and _all into
+// the existing indexes, names with a leading - exclude the indexes matched
+// by the names before
+func (s *Server) resolve(expr string) []string {
+	found := map[string]bool{}
+	for _, name := range strings.Split(expr, ",") {
+		if name == "_all" {
+			name = "*"
+		}
+		excluded := strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+		for existing, idx := range s.indices {
+			matched, _ := path.Match(name, existing)
+			for alias := range idx.Aliases {
+				if ok, _ := path.Match(name, alias); ok {
+					matched = true
+				}
+			}
+			if matched {
+				found[existing] = !excluded
+			}
+		}
+	}
+	for name, ok := range found {
+		if !ok {
+			delete(found, name)
+		}
+	}
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) send(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *Server) sendError(w http.ResponseWriter, status int, errorType string, reason string) {
+	if s.major() < 5 {
+		s.send(w, status, map[string]interface{}{"error": fmt.Sprintf("%s[%s]", errorType, reason), "status": status})
+		return
+	}
+	cause := map[string]interface{}{"type": errorType, "reason": reason}
+	cause["root_cause"] = []interface{}{map[string]interface{}{"type": errorType, "reason": reason}}
+	s.send(w, status, map[string]interface{}{"error": cause, "status": status})
+}
+
+func (s *Server) total(n int) interface{} {
+	if s.major() >= 7 {
+		return map[string]interface{}{"value": n, "relation": "eq"}
+	}
+	return n
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	var reader io.Reader = r.Body
+	if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			s.sendError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	body, _ := io.ReadAll(reader)
+	parts := []string{}
+	for _, part := range strings.Split(r.URL.Path, "/") {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	query := r.URL.Query()
+
+	s.lock.Lock()
+	delay := s.delay
+	s.lock.Unlock()
+	time.Sleep(delay)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failed(w, r) {
+		return
+	}
+
+	if len(parts) == 0 {
+		s.info(w)
+		return
+	}
+
+	switch parts[0] {
+	case "_cluster":
+		s.send(w, http.StatusOK, map[string]interface{}{"cluster_name": "esmtest", "status": "green", "timed_out": false, "number_of_nodes": 1})
+		return
+	case "_nodes":
+		pool := "write"
+		if s.major() < 6 {
+			pool = "bulk"
+		}
+		s.send(w, http.StatusOK, map[string]interface{}{"nodes": map[string]interface{}{"node-1": map[string]interface{}{"thread_pool": map[string]interface{}{pool: map[string]interface{}{"threads": 1, "queue": 0}}}}})
+		return
+	case "_bulk":
+		s.bulk(w, body)
+		return
+	case "_search":
+		if len(parts) > 1 && parts[1] == "scroll" {
+			s.nextScroll(w, r.Method, query, body)
+			return
+		}
+		parts = append([]string{"_all"}, parts...)
+	case "_aliases":
+		s.updateAliases(w, body)
+		return
+	case "_count", "_mapping", "_settings", "_stats", "_refresh", "_alias":
+		parts = append([]string{"_all"}, parts...)
+	}
+
+	name := parts[0]
+	if len(parts) == 1 {
+		s.index(w, r.Method, name, body)
+		return
+	}
+	if parts[1] == "_alias" {
+		s.alias(w, name, parts[2:])
+		return
+	}
+
+	switch parts[len(parts)-1] {
+	case "_search":
+		s.search(w, name, query, body)
+	case "_count":
+		count := 0
+		for _, n := range s.resolve(name) {
+			count += len(s.indices[n].Docs)
+		}
+		s.send(w, http.StatusOK, map[string]interface{}{"count": count})
+	case "_mapping":
+		s.mapping(w, r.Method, name, body)
+	case "_settings":
+		s.settings(w, r.Method, name, body)
+	case "_refresh", "_flush", "_open", "_close":
+		s.send(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	default:
+		if len(parts) >= 2 && parts[1] == "_stats" {
+			s.stats(w, name)
+			return
+		}
+		s.sendError(w, http.StatusBadRequest, "illegal_argument_exception", "unsupported request "+r.Method+" "+r.URL.Path)
+	}
+}
+
+func (s *Server) info(w http.ResponseWriter) {
+	version := map[string]interface{}{"number": s.Version, "lucene_version": "esmtest"}
+	if s.major() >= 6 {
+		version["build_flavor"] = "default"
+		version["minimum_wire_compatibility_version"] = s.Version
+	}
+	info := map[string]interface{}{
+		"name":         "esmtest",
+		"cluster_name": "esmtest",
+		"version":      version,
+		"tagline":      "You Know, for Search",
+	}
+	if s.major() < 5 {
+		info["status"] = http.StatusOK
+	}
+	s.send(w, http.StatusOK, info)
+}
+
+func (s *Server) index(w http.ResponseWriter, method string, name string, body []byte) {
+	switch method {
+	case http.MethodPut, http.MethodPost:
+		if _, ok := s.indices[name]; ok {
+			s.sendError(w, http.StatusBadRequest, "resource_already_exists_exception", "index ["+name+"] already exists")
+			return
+		}
+		idx := newIndex()
+		request := map[string]interface{}{}
+		json.Unmarshal(body, &request)
+		if settings, ok := request["settings"].(map[string]interface{}); ok {
+			mergeSettings(idx.Settings, settings)
+		}
+		if mappings, ok := request["mappings"].(map[string]interface{}); ok {
+			idx.Mappings = mappings
+		}
+		if aliases, ok := request["aliases"].(map[string]interface{}); ok {
+			idx.Aliases = aliases
+		}
+		s.indices[name] = idx
+		s.send(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	case http.MethodDelete:
+		names := s.resolve(name)
+		if len(names) == 0 {
+			s.sendError(w, http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+			return
+		}
+		for _, n := range names {
+			delete(s.indices, n)
+		}
+		s.send(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+	default:
+		if _, ok := s.indices[name]; !ok {
+			s.sendError(w, http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+			return
+		}
+		s.send(w, http.StatusOK, map[string]interface{}{name: map[string]interface{}{}})
+	}
+}
+
+// mergeSettings copies nested or flat index settings, "index." prefixes are
+// dropped
+func mergeSettings(into map[string]interface{}, settings map[string]interface{}) {
+	if index, ok := settings["index"].(map[string]interface{}); ok {
+		mergeSettings(into, index)
+	}
+	for k, v := range settings {
+		if k == "index" {
+			continue
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			for nk, nv := range nested {
+				into[strings.TrimPrefix(k, "index.")+"."+nk] = nv
+			}
+			continue
+		}
+		into[strings.TrimPrefix(k, "index.")] = fmt.Sprint(v)
+	}
+}
+
+func (s *Server) settings(w http.ResponseWriter, method string, name string, body []byte) {
+	names := s.resolve(name)
+	if len(names) == 0 {
+		s.sendError(w, http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+		return
+	}
+	if method == http.MethodPut || method == http.MethodPost {
+		request := map[string]interface{}{}
+		if err := json.Unmarshal(body, &request); err != nil {
+			s.sendError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return
+		}
+		if settings, ok := request["settings"].(map[string]interface{}); ok {
+			request = settings
+		}
+		for _, n := range names {
+			mergeSettings(s.indices[n].Settings, request)
+		}
+		s.send(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+		return
+	}
+	response := map[string]interface{}{}
+	for _, n := range names {
+		index := map[string]interface{}{}
+		for k, v := range s.indices[n].Settings {
+			index[k] = v
+		}
+		response[n] = map[string]interface{}{"settings": map[string]interface{}{"index": index}}
+	}
+	s.send(w, http.StatusOK, response)
+}
+
+func (s *Server) mapping(w http.ResponseWriter, method string, name string, body []byte) {
+	if method == http.MethodPut || method == http.MethodPost {
+		idx, ok := s.indices[name]
+		if !ok {
+			s.sendError(w, http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+			return
+		}
+		mappings := map[string]interface{}{}
+		if err := json.Unmarshal(body, &mappings); err != nil {
+			s.sendError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return
+		}
+		for k, v := range mappings {
+			idx.Mappings[k] = v
+		}
+		s.send(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+		return
+	}
+	names := s.resolve(name)
+	if len(names) == 0 {
+		s.sendError(w, http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+		return
+	}
+	response := map[string]interface{}{}
+	for _, n := range names {
+		mappings := s.indices[n].Mappings
+		// mappings are keyed by type before 7.x
+		if _, typed := mappings["properties"]; typed && s.major() < 7 {
+			mappings = map[string]interface{}{s.docType(): mappings}
+		}
+		response[n] = map[string]interface{}{"mappings": mappings}
+	}
+	s.send(w, http.StatusOK, response)
+}
+
+// alias answers the aliases of the indexes, filtered by the alias names
+// if given
+func (s *Server) alias(w http.ResponseWriter, name string, aliasNames []string) {
+	names := s.resolve(name)
+	if len(names) == 0 && name != "_all" {
+		s.sendError(w, http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+		return
+	}
+	response := map[string]interface{}{}
+	for _, n := range names {
+		aliases := map[string]interface{}{}
+		for alias, definition := range s.indices[n].Aliases {
+			if len(aliasNames) > 0 && !matchAny(strings.Split(aliasNames[0], ","), alias) {
+				continue
+			}
+			aliases[alias] = definition
+		}
+		if len(aliasNames) > 0 && len(aliases) == 0 {
+			continue
+		}
+		response[n] = map[string]interface{}{"aliases": aliases}
+	}
+	if len(aliasNames) > 0 && len(response) == 0 {
+		s.send(w, http.StatusNotFound, map[string]interface{}{"error": "alias [" + aliasNames[0] + "] missing", "status": http.StatusNotFound})
+		return
+	}
+	s.send(w, http.StatusOK, response)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok || pattern == "_all" {
+			return true
+		}
+	}
+	return false
+}
+
+// updateAliases applies the add, remove and remove_index actions, all or
+// none of them
+func (s *Server) updateAliases(w http.ResponseWriter, body []byte) {
+	request := struct {
+		Actions []map[string]struct {
+			Index   string                 `json:"index"`
+			Indices []string               `json:"indices"`
+			Alias   string                 `json:"alias"`
+			Aliases []string               `json:"aliases"`
+			Filter  map[string]interface{} `json:"filter"`
+			Routing string                 `json:"routing"`
+		} `json:"actions"`
+	}{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		s.sendError(w, http.StatusBadRequest, "parse_exception", err.Error())
+		return
+	}
+	apply := []func(){}
+	for _, action := range request.Actions {
+		for op, a := range action {
+			expr := strings.Join(append(a.Indices, a.Index), ",")
+			names := s.resolve(strings.Trim(expr, ","))
+			if len(names) == 0 {
+				s.sendError(w, http.StatusNotFound, "index_not_found_exception", "no such index ["+expr+"]")
+				return
+			}
+			aliases := append(a.Aliases, a.Alias)
+			definition := map[string]interface{}{}
+			if a.Filter != nil {
+				definition["filter"] = a.Filter
+			}
+			if len(a.Routing) > 0 {
+				definition["index_routing"], definition["search_routing"] = a.Routing, a.Routing
+			}
+			for _, n := range names {
+				idx := s.indices[n]
+				switch op {
+				case "add":
+					apply = append(apply, func() {
+						for _, alias := range aliases {
+							if len(alias) > 0 {
+								idx.Aliases[alias] = definition
+							}
+						}
+					})
+				case "remove":
+					for _, alias := range aliases {
+						if _, ok := idx.Aliases[alias]; len(alias) > 0 && !ok {
+							s.sendError(w, http.StatusNotFound, "aliases_not_found_exception", "aliases ["+alias+"] missing")
+							return
+						}
+					}
+					apply = append(apply, func() {
+						for _, alias := range aliases {
+							delete(idx.Aliases, alias)
+						}
+					})
+				case "remove_index":
+					name := n
+					apply = append(apply, func() { delete(s.indices, name) })
+				default:
+					s.sendError(w, http.StatusBadRequest, "illegal_argument_exception", "unsupported alias action "+op)
+					return
+				}
+			}
+		}
+	}
+	for _, f := range apply {
+		f()
+	}
+	s.send(w, http.StatusOK, map[string]interface{}{"acknowledged": true})
+}
+
+func (s *Server) stats(w http.ResponseWriter, name string) {
+	indices := map[string]interface{}{}
+	for _, n := range s.resolve(name) {
+		size := 0
+		for _, doc := range s.indices[n].Docs {
+			size += len(doc.Source)
+		}
+		indices[n] = map[string]interface{}{"primaries": map[string]interface{}{
+			"docs":  map[string]interface{}{"count": len(s.indices[n].Docs)},
+			"store": map[string]interface{}{"size_in_bytes": size},
+		}}
+	}
+	s.send(w, http.StatusOK, map[string]interface{}{"indices": indices})
+}
+
+func (s *Server) hit(index string, id string, doc *Doc) map[string]interface{} {
+	hit := map[string]interface{}{"_index": index, "_id": id, "_score": 1, "_source": doc.Source}
+	if s.major() < 8 {
+		hit["_type"] = doc.Type
+	}
+	if len(doc.Routing) > 0 {
+		hit["_routing"] = doc.Routing
+	}
+	return hit
+}
+
+// search opens a scroll, or answers the stats aggregation of a field. Only
+// slices and shard preferences filter the documents, queries are ignored.
+func (s *Server) search(w http.ResponseWriter, name string, query url.Values, body []byte) {
+	names := s.resolve(name)
+	if len(names) == 0 {
+		s.sendError(w, http.StatusNotFound, "index_not_found_exception", "no such index ["+name+"]")
+		return
+	}
+	request := struct {
+		Slice *struct {
+			Id  int `json:"id"`
+			Max int `json:"max"`
+		} `json:"slice"`
+		Aggs map[string]struct {
+			Stats *struct {
+				Field string `json:"field"`
+			} `json:"stats"`
+		} `json:"aggs"`
+	}{}
+	json.Unmarshal(body, &request)
+
+	shard := -1
+	if preference := query.Get("preference"); strings.HasPrefix(preference, "_shards:") {
+		shard, _ = strconv.Atoi(strings.TrimPrefix(preference, "_shards:"))
+	}
+
+	hits := []map[string]interface{}{}
+	for _, n := range names {
+		idx := s.indices[n]
+		shards, _ := strconv.Atoi(fmt.Sprint(idx.Settings["number_of_shards"]))
+		for i, id := range idx.ids {
+			if shard >= 0 && shards > 0 && i%shards != shard {
+				continue
+			}
+			hits = append(hits, s.hit(n, id, idx.Docs[id]))
+		}
+	}
+	if request.Slice != nil && request.Slice.Max > 1 {
+		sliced := []map[string]interface{}{}
+		for i, hit := range hits {
+			if i%request.Slice.Max == request.Slice.Id {
+				sliced = append(sliced, hit)
+			}
+		}
+		hits = sliced
+	}
+
+	if agg, ok := request.Aggs["stats"]; ok && agg.Stats != nil {
+		s.send(w, http.StatusOK, map[string]interface{}{
+			"hits":         map[string]interface{}{"total": s.total(len(hits)), "hits": []interface{}{}},
+			"aggregations": map[string]interface{}{"stats": fieldStats(hits, agg.Stats.Field)},
+		})
+		return
+	}
+
+	size, err := strconv.Atoi(query.Get("size"))
+	if err != nil || size < 1 {
+		size = 10
+	}
+	s.scrollId++
+	id := fmt.Sprintf("scroll-%d", s.scrollId)
+	s.scrolls[id] = &scroll{hits: hits, size: size}
+
+	// a scan returns no documents on the first page
+	if query.Get("search_type") == "scan" {
+		s.send(w, http.StatusOK, map[string]interface{}{
+			"_scroll_id": id,
+			"_shards":    map[string]interface{}{"total": 1, "successful": 1, "failed": 0},
+			"hits":       map[string]interface{}{"total": s.total(len(hits)), "hits": []interface{}{}},
+		})
+		return
+	}
+	s.page(w, id)
+}
+
+func fieldStats(hits []map[string]interface{}, field string) map[string]interface{} {
+	stats := map[string]interface{}{"count": 0, "min": nil, "max": nil}
+	count := 0
+	var min, max float64
+	for _, hit := range hits {
+		source := map[string]interface{}{}
+		json.Unmarshal(hit["_source"].(json.RawMessage), &source)
+		v, ok := source[field].(float64)
+		if !ok {
+			continue
+		}
+		if count == 0 || v < min {
+			min = v
+		}
+		if count == 0 || v > max {
+			max = v
+		}
+		count++
+	}
+	if count > 0 {
+		stats["count"], stats["min"], stats["max"] = count, min, max
+	}
+	return stats
+}
+
+func (s *Server) page(w http.ResponseWriter, id string) {
+	sc := s.scrolls[id]
+	end := sc.pos + sc.size
+	if end > len(sc.hits) {
+		end = len(sc.hits)
+	}
+	hits := sc.hits[sc.pos:end]
+	sc.pos = end
+	s.send(w, http.StatusOK, map[string]interface{}{
+		"_scroll_id": id,
+		"took":       1,
+		"timed_out":  false,
+		"_shards":    map[string]interface{}{"total": 1, "successful": 1, "failed": 0},
+		"hits":       map[string]interface{}{"total": s.total(len(sc.hits)), "hits": hits},
+	})
+}
+
+// nextScroll continues or clears a scroll, the id is a parameter, the raw
+// body or a json body
+func (s *Server) nextScroll(w http.ResponseWriter, method string, query url.Values, body []byte) {
+	ids := []string{}
+	if id := query.Get("scroll_id"); len(id) > 0 {
+		ids = append(ids, id)
+	}
+	request := struct {
+		ScrollId interface{} `json:"scroll_id"`
+	}{}
+	if err := json.Unmarshal(body, &request); err == nil {
+		switch v := request.ScrollId.(type) {
+		case string:
+			ids = append(ids, v)
+		case []interface{}:
+			for _, id := range v {
+				ids = append(ids, fmt.Sprint(id))
+			}
+		}
+	} else if len(body) > 0 {
+		ids = append(ids, strings.TrimSpace(string(body)))
+	}
+
+	if method == http.MethodDelete {
+		freed := 0
+		for _, id := range ids {
+			if _, ok := s.scrolls[id]; ok {
+				delete(s.scrolls, id)
+				freed++
+			}
+		}
+		s.send(w, http.StatusOK, map[string]interface{}{"succeeded": true, "num_freed": freed})
+		return
+	}
+
+	if len(ids) == 0 || s.scrolls[ids[0]] == nil {
+		s.sendError(w, http.StatusNotFound, "search_context_missing_exception", "No search context found")
+		return
+	}
+	s.page(w, ids[0])
+}
+
+// bulk indexes, creates and deletes documents, every item succeeds
+func (s *Server) bulk(w http.ResponseWriter, body []byte) {
+	items := []interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 1024*1024), len(body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		action := map[string]struct {
+			Index         string `json:"_index"`
+			Type          string `json:"_type"`
+			Id            string `json:"_id"`
+			Routing       string `json:"routing"`
+			LegacyRouting string `json:"_routing"`
+		}{}
+		if err := json.Unmarshal(line, &action); err != nil {
+			s.sendError(w, http.StatusBadRequest, "parse_exception", err.Error())
+			return
+		}
+		for op, meta := range action {
+			idx, ok := s.indices[meta.Index]
+			if !ok {
+				idx = newIndex()
+				s.indices[meta.Index] = idx
+			}
+			if len(meta.Type) == 0 {
+				meta.Type = s.docType()
+			}
+			if len(meta.Id) == 0 {
+				s.autoId++
+				meta.Id = fmt.Sprintf("auto-%d", s.autoId)
+			}
+			status := http.StatusCreated
+			if op == "delete" {
+				idx.remove(meta.Id)
+				status = http.StatusOK
+			} else {
+				if !scanner.Scan() {
+					s.sendError(w, http.StatusBadRequest, "parse_exception", "missing source of "+meta.Id)
+					return
+				}
+				routing := meta.Routing
+				if len(routing) == 0 {
+					routing = meta.LegacyRouting
+				}
+				source := append(json.RawMessage{}, scanner.Bytes()...)
+				idx.put(meta.Id, &Doc{Type: meta.Type, Routing: routing, Source: source})
+			}
+			item := map[string]interface{}{"_index": meta.Index, "_id": meta.Id, "status": status}
+			if s.major() < 8 {
+				item["_type"] = meta.Type
+			}
+			items = append(items, map[string]interface{}{op: item})
+		}
+	}
+	s.send(w, http.StatusOK, map[string]interface{}{"took": 1, "errors": false, "items": items})
+}
@@ -1,14 +1,44 @@
 package main
 
 import (
+	"os"
 	"strings"
 
 	log "github.com/cihub/seelog"
 )
 
-func setInitLogging(logLevel string) {
+// stderrReceiver is the console of the log when the documents are dumped
+// to stdout
+type stderrReceiver struct{}
+
+func (r *stderrReceiver) ReceiveMessage(message string, level log.LogLevel, context log.LogContextInterface) error {
+	_, err := os.Stderr.WriteString(message)
+	return err
+}
+
+func (r *stderrReceiver) AfterParse(initArgs log.CustomReceiverInitArgs) error {
+	return nil
+}
+
+func (r *stderrReceiver) Flush() {}
+
+func (r *stderrReceiver) Close() error {
+	return nil
+}
+
+func init() {
+	log.RegisterReceiver("stderr", &stderrReceiver{})
+}
+
+// setInitLogging logs to the console, stdout or stderr, and errors also to
+// esm.log
+func setInitLogging(logLevel string, console *os.File) {
 
 	logLevel = strings.ToLower(logLevel)
+	consoleOutput := `<console formatid="main" />`
+	if console == os.Stderr {
+		consoleOutput = `<custom name="stderr" formatid="main" />`
+	}
 
 	testConfig := `
 	<seelog  type="sync" minlevel="`
@@ -18,7 +48,7 @@ func setInitLogging(logLevel string) {
 			<filter levels="error">
 				<file path="./esm.log"/>
 			</filter>
-			<console formatid="main" />
+			` + consoleOutput + `
 		</outputs>
 		<formats>
 			<format id="main" format="[%Date(01-02) %Time] [%LEV] [%File:%Line,%FuncShort] %Msg%n"/>
@@ -1,588 +1,223 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"runtime"
 	_ "runtime/pprof"
-	"strings"
 	"sync"
-	"time"
+	"syscall"
 
-	"github.com/cheggaaa/pb"
 	log "github.com/cihub/seelog"
 	goflags "github.com/jessevdk/go-flags"
-	"github.com/mattn/go-isatty"
+	"github.com/raminhz90/esm/migrate"
 )
 
 func main() {
 
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
-	go func() {
-		//log.Infof("pprof listen at: http://%s/debug/pprof/", app.httpprof)
-		mux := http.NewServeMux()
-
-		// register pprof handler
-		mux.HandleFunc("/debug/pprof/", func(w http.ResponseWriter, r *http.Request) {
-			http.DefaultServeMux.ServeHTTP(w, r)
-		})
-
-		// register metrics handler
-		//mux.HandleFunc("/debug/vars", app.metricsHandler)
-
-		endpoint := http.ListenAndServe("0.0.0.0:6060", mux)
-		log.Debug("stop pprof server: %v", endpoint)
-	}()
+	setInitLogging("info", os.Stdout)
 
-	var err error
-	c := &Config{}
-	migrator := Migrator{}
-	migrator.Config = c
+	// the old form without a command copies
+	args := os.Args[1:]
+	cmd := findCommand(args)
+	if cmd != nil {
+		args = args[1:]
+	}
 
-	// parse args
-	_, err = goflags.Parse(c)
+	// merge config file, environment and command line
+	jobArgs, err := loadJobArgs(args)
 	if err != nil {
 		log.Error(err)
-		return
+		log.Flush()
+		os.Exit(migrate.ExitFatal)
 	}
 
-	setInitLogging(c.LogLevel)
-
-	if len(c.SourceEs) == 0 && len(c.DumpInputFile) == 0 {
-		log.Error("no input, type --help for more details")
-		return
-	}
-	if len(c.TargetEs) == 0 && len(c.DumpOutFile) == 0 {
-		log.Error("no output, type --help for more details")
-		return
+	// parse args
+	configs := []*migrate.Config{}
+	for _, args := range jobArgs {
+		c, err := parseConfig(cmd, args)
+		if err != nil {
+			if flagsErr, ok := err.(*goflags.Error); ok && flagsErr.Type == goflags.ErrHelp {
+				os.Exit(migrate.ExitSuccess)
+			}
+			log.Error(err)
+			log.Flush()
+			os.Exit(migrate.ExitFatal)
+		}
+		configs = append(configs, c)
 	}
 
-	if c.SourceEs == c.TargetEs && c.SourceIndexNames == c.TargetIndexName {
-		log.Error("migration output is the same as the output")
-		return
-	}
+	c := configs[0]
 
-	var showBar bool = false
-	if isatty.IsTerminal(os.Stdout.Fd()) {
-		showBar = true
-	} else if isatty.IsCygwinTerminal(os.Stdout.Fd()) {
-		showBar = true
-	} else {
-		showBar = false
+	// with documents on stdout, everything else goes to stderr
+	console := os.Stdout
+	stdin, stdout := 0, 0
+	for _, jobConfig := range configs {
+		if jobConfig.DumpInputFile == "-" {
+			stdin++
+		}
+		if jobConfig.DumpOutFile == "-" {
+			stdout++
+			console = jobConfig.Console()
+		}
 	}
-	if c.RepeatOutputTimes < 1 {
-		c.RepeatOutputTimes = 1
-	} else {
-		log.Info("source data will repeat send to target: ", c.RepeatOutputTimes, " times, the document id will be regenerated.")
+	setInitLogging(c.LogLevel, console)
+	if stdin > 1 || stdout > 1 {
+		log.Error("only one job can read stdin and one write stdout")
+		log.Flush()
+		os.Exit(migrate.ExitFatal)
 	}
 
-	if c.RepeatOutputTimes > 0 {
-
-		for i := 0; i < c.RepeatOutputTimes; i++ {
-
-			if c.RepeatOutputTimes > 1 {
-				log.Info("repeat round: ", i+1)
-			}
-
-			// enough of a buffer to hold all the search results across all workers
-			migrator.DocChan = make(chan map[string]interface{}, c.BufferCount)
-
-			var srcESVersion *ClusterVersion
-			// create a progressbar and start a docCount
-			var outputBar *pb.ProgressBar = pb.New(1).Prefix("Output ")
-
-			var fetchBar = pb.New(1).Prefix("Scroll")
-
-			wg := sync.WaitGroup{}
-
-			//dealing with input
-			if len(c.SourceEs) > 0 {
-				//dealing with basic auth
-				if len(c.SourceEsAuthStr) > 0 && strings.Contains(c.SourceEsAuthStr, ":") {
-					authArray := strings.Split(c.SourceEsAuthStr, ":")
-					auth := Auth{User: authArray[0], Pass: authArray[1]}
-					migrator.SourceAuth = &auth
-				}
-
-				//get source es version
-				srcESVersion, errs := migrator.ClusterVersion(c.SourceEs, migrator.SourceAuth, migrator.Config.SourceProxy)
-				if errs != nil {
-					return
-				}
-				if strings.HasPrefix(srcESVersion.Version.Number, "8.") {
-					log.Debug("source es is V8,", srcESVersion.Version.Number)
-					api := new(ESAPIV7)
-					api.Host = c.SourceEs
-					api.Compress = c.Compress
-					api.Auth = migrator.SourceAuth
-					api.HttpProxy = migrator.Config.SourceProxy
-					migrator.SourceESAPI = api
-				} else if strings.HasPrefix(srcESVersion.Version.Number, "7.") {
-					log.Debug("source es is V7,", srcESVersion.Version.Number)
-					api := new(ESAPIV7)
-					api.Host = c.SourceEs
-					api.Compress = c.Compress
-					api.Auth = migrator.SourceAuth
-					api.HttpProxy = migrator.Config.SourceProxy
-					migrator.SourceESAPI = api
-				} else if strings.HasPrefix(srcESVersion.Version.Number, "6.") {
-					log.Debug("source es is V6,", srcESVersion.Version.Number)
-					api := new(ESAPIV6)
-					api.Compress = c.Compress
-					api.Host = c.SourceEs
-					api.Auth = migrator.SourceAuth
-					api.HttpProxy = migrator.Config.SourceProxy
-					migrator.SourceESAPI = api
-				} else if strings.HasPrefix(srcESVersion.Version.Number, "5.") {
-					log.Debug("source es is V5,", srcESVersion.Version.Number)
-					api := new(ESAPIV5)
-					api.Host = c.SourceEs
-					api.Compress = c.Compress
-					api.Auth = migrator.SourceAuth
-					api.HttpProxy = migrator.Config.SourceProxy
-					migrator.SourceESAPI = api
-				} else {
-					log.Debug("source es is not V5,", srcESVersion.Version.Number)
-					api := new(ESAPIV0)
-					api.Host = c.SourceEs
-					api.Compress = c.Compress
-					api.Auth = migrator.SourceAuth
-					api.HttpProxy = migrator.Config.SourceProxy
-					migrator.SourceESAPI = api
-				}
-
-				if c.ScrollSliceSize < 1 {
-					c.ScrollSliceSize = 1
-				}
-
-				totalSize := 0
-				finishedSlice := 0
-				for slice := 0; slice < c.ScrollSliceSize; slice++ {
-					scroll, err := migrator.SourceESAPI.NewScroll(c.SourceIndexNames, c.ScrollTime, c.DocBufferCount, c.Query, slice, c.ScrollSliceSize, c.Fields)
-					if err != nil {
-						log.Error(err)
-						return
-					}
-
-					temp := scroll.(ScrollAPI)
-
-					totalSize += temp.GetHitsTotal()
-
-					if scroll != nil && temp.GetDocs() != nil {
-
-						if temp.GetHitsTotal() == 0 {
-							log.Error("can't find documents from source.")
-							return
-						}
-
-						wg.Add(1)
-						go func() {
-							//process input
-							// start scroll
-							temp.ProcessScrollResult(&migrator, fetchBar)
-
-							// loop scrolling until done
-							for !temp.Next(&migrator, fetchBar) {
-							}
-
-							if showBar {
-								fetchBar.Finish()
-							}
-
-							// finished, close doc chan and wait for goroutines to be done
-							wg.Done()
-							finishedSlice++
-
-							//clean up final results
-							if finishedSlice == c.ScrollSliceSize {
-								log.Debug("closing doc chan")
-								close(migrator.DocChan)
-							}
-						}()
-					}
-				}
-
-				if totalSize > 0 {
-					fetchBar.Total = int64(totalSize)
-					outputBar.Total = int64(totalSize)
-				}
-
-			} else if len(c.DumpInputFile) > 0 {
-				//read file stream
-				wg.Add(1)
-				f, err := os.Open(c.DumpInputFile)
-				if err != nil {
-					log.Error(err)
-					return
-				}
-				//get file lines
-				lineCount := 0
-				defer f.Close()
-				r := bufio.NewReader(f)
-				for {
-					_, err := r.ReadString('\n')
-					if io.EOF == err || nil != err {
-						break
-					}
-					lineCount += 1
-				}
-				log.Trace("file line,", lineCount)
-
-				fetchBar := pb.New(lineCount).Prefix("Read")
-				outputBar = pb.New(lineCount).Prefix("Output ")
+	if cmd != nil && cmd.name == "inspect" {
+		inspect(configs)
+	}
 
-				f.Close()
+	metrics := migrate.NewMetrics()
+	if len(c.HttpListen) > 0 {
+		go metrics.StartHTTPServer(c.HttpListen)
+	}
 
-				go migrator.NewFileReadWorker(fetchBar, &wg)
+	// jobs of a batch share one progress view
+	var progress *migrate.Progress
+	if len(configs) > 1 {
+		progress = migrate.StartProgress(console)
+	}
 
-			}
+	parallel := c.ParallelJobs
+	if parallel < 1 {
+		parallel = 1
+	}
 
-			var pool *pb.Pool
-			if showBar {
+	var errs []error
+	errsLock := sync.Mutex{}
+	plans := make([]*migrate.Plan, len(configs))
+	finished := make([]bool, len(configs))
+	migrators := make([]*migrate.Migrator, len(configs))
+	for i, jobConfig := range configs {
+		migrators[i] = migrate.NewMigrator(jobConfig)
+		migrators[i].Metrics = metrics
+		migrators[i].Progress = progress
+	}
 
-				// start pool
-				pool, err = pb.StartPool(fetchBar, outputBar)
-				if err != nil {
-					panic(err)
-				}
+	// the first signal stops reading and lets the workers flush what was
+	// read, the second one exits at once after restoring target index settings
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() {
+		sigs := make(chan os.Signal, 2)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		sig := <-sigs
+		log.Warnf("received %s, stopping readers and flushing buffered documents, repeat to exit now", sig)
+		cause := fmt.Errorf("received %s", sig)
+		cancel(cause)
+		for _, migrator := range migrators {
+			migrator.Stop(cause)
+		}
+		sig = <-sigs
+		log.Warnf("received %s, restoring target index settings", sig)
+		for _, migrator := range migrators {
+			if err := migrator.Restore.Restore(false, 0); err != nil {
+				log.Error(err)
 			}
+		}
+		log.Flush()
+		os.Exit(migrate.ExitFatal)
+	}()
 
-			//dealing with output
-			if len(c.TargetEs) > 0 {
-				if len(c.TargetEsAuthStr) > 0 && strings.Contains(c.TargetEsAuthStr, ":") {
-					authArray := strings.Split(c.TargetEsAuthStr, ":")
-					auth := Auth{User: authArray[0], Pass: authArray[1]}
-					migrator.TargetAuth = &auth
-				}
-
-				//get target es version
-				descESVersion, errs := migrator.ClusterVersion(c.TargetEs, migrator.TargetAuth, migrator.Config.TargetProxy)
-				if errs != nil {
-					return
-				}
-				if strings.HasPrefix(descESVersion.Version.Number, "9.") {
-					log.Debug("target es is V9,", descESVersion.Version.Number)
-					api := new(ESAPIV8)
-					api.Host = c.TargetEs
-					api.Auth = migrator.TargetAuth
-					api.HttpProxy = migrator.Config.TargetProxy
-					migrator.TargetESAPI = api
-				}else if strings.HasPrefix(descESVersion.Version.Number, "8.") {
-					log.Debug("target es is V8,", descESVersion.Version.Number)
-					api := new(ESAPIV8)
-					api.Host = c.TargetEs
-					api.Auth = migrator.TargetAuth
-					api.HttpProxy = migrator.Config.TargetProxy
-					migrator.TargetESAPI = api
-				} else if strings.HasPrefix(descESVersion.Version.Number, "7.") {
-					log.Debug("target es is V7,", descESVersion.Version.Number)
-					api := new(ESAPIV7)
-					api.Host = c.TargetEs
-					api.Auth = migrator.TargetAuth
-					api.HttpProxy = migrator.Config.TargetProxy
-					migrator.TargetESAPI = api
-				} else if strings.HasPrefix(descESVersion.Version.Number, "6.") {
-					log.Debug("target es is V6,", descESVersion.Version.Number)
-					api := new(ESAPIV6)
-					api.Host = c.TargetEs
-					api.Auth = migrator.TargetAuth
-					api.HttpProxy = migrator.Config.TargetProxy
-					migrator.TargetESAPI = api
-				} else if strings.HasPrefix(descESVersion.Version.Number, "5.") {
-					log.Debug("target es is V5,", descESVersion.Version.Number)
-					api := new(ESAPIV5)
-					api.Host = c.TargetEs
-					api.Auth = migrator.TargetAuth
-					api.HttpProxy = migrator.Config.TargetProxy
-					migrator.TargetESAPI = api
-				} else {
-					log.Debug("target es is not recognized,", descESVersion.Version.Number)
-					log.Warn("target es version is either older than 5 or newer than 8, trying pre V5 apis ....")
-					api := new(ESAPIV0)
-					api.Host = c.TargetEs
-					api.Auth = migrator.TargetAuth
-					api.HttpProxy = migrator.Config.TargetProxy
-					migrator.TargetESAPI = api
-
-				}
-
-				log.Debug("start process with mappings")
-				if srcESVersion != nil && c.CopyIndexMappings && descESVersion.Version.Number[0] != srcESVersion.Version.Number[0] {
-					log.Error(srcESVersion.Version, "=>", descESVersion.Version, ",cross-big-version mapping migration not avaiable, please update mapping manually :(")
-					return
-				}
-
-				// wait for cluster state to be okay before moving
-				idleDuration := 3 * time.Second
-				timer := time.NewTimer(idleDuration)
-				defer timer.Stop()
-				for {
-					timer.Reset(idleDuration)
-
-					if len(c.SourceEs) > 0 {
-						if status, ready := migrator.ClusterReady(migrator.SourceESAPI); !ready {
-							log.Infof("%s at %s is %s, delaying migration ", status.Name, c.SourceEs, status.Status)
-							<-timer.C
-							continue
-						}
-					}
-
-					if len(c.TargetEs) > 0 {
-						if status, ready := migrator.ClusterReady(migrator.TargetESAPI); !ready {
-							log.Infof("%s at %s is %s, delaying migration ", status.Name, c.TargetEs, status.Status)
-							<-timer.C
-							continue
-						}
-					}
-					break
-				}
-
-				if len(c.SourceEs) > 0 {
-					// get all indexes from source
-					indexNames, indexCount, sourceIndexMappings, err := migrator.SourceESAPI.GetIndexMappings(c.CopyAllIndexes, c.SourceIndexNames)
-
-					if err != nil {
-						log.Error(err)
-						return
-					}
-
-					sourceIndexRefreshSettings := map[string]interface{}{}
-
-					log.Debugf("indexCount: %d", indexCount)
-
-					if indexCount > 0 {
-						//override indexnames to be copy
-						c.SourceIndexNames = indexNames
-
-						// copy index settings if user asked
-						if c.CopyIndexSettings || c.ShardsCount > 0 {
-							log.Info("start settings/mappings migration..")
-
-							//get source index settings
-							var sourceIndexSettings *Indexes
-							sourceIndexSettings, err := migrator.SourceESAPI.GetIndexSettings(c.SourceIndexNames)
-							log.Debug("source index settings:", sourceIndexSettings)
-							if err != nil {
-								log.Error(err)
-								return
-							}
-
-							//get target index settings
-							targetIndexSettings, err := migrator.TargetESAPI.GetIndexSettings(c.TargetIndexName)
-							if err != nil {
-								//ignore target es settings error
-								log.Debug(err)
-							}
-							log.Debug("target IndexSettings", targetIndexSettings)
-
-							//if there is only one index and we specify the dest indexname
-							if c.SourceIndexNames != c.TargetIndexName && (len(c.TargetIndexName) > 0) && indexCount == 1 {
-								log.Debugf("only one index,so we can rewrite indexname, src:%v, dest:%v ,indexCount:%d", c.SourceIndexNames, c.TargetIndexName, indexCount)
-								(*sourceIndexSettings)[c.TargetIndexName] = (*sourceIndexSettings)[c.SourceIndexNames]
-								delete(*sourceIndexSettings, c.SourceIndexNames)
-								log.Debug(sourceIndexSettings)
-							}
-
-							// dealing with indices settings
-							for name, idx := range *sourceIndexSettings {
-								log.Debug("dealing with index,name:", name, ",settings:", idx)
-								tempIndexSettings := getEmptyIndexSettings()
-
-								targetIndexExist := false
-								//if target index settings is exist and we don't copy settings, we use target settings
-								if targetIndexSettings != nil {
-									//if target es have this index and we dont copy index settings
-									if val, ok := (*targetIndexSettings)[name]; ok {
-										targetIndexExist = true
-										tempIndexSettings = val.(map[string]interface{})
-									}
-
-									if c.RecreateIndex {
-										migrator.TargetESAPI.DeleteIndex(name)
-										targetIndexExist = false
-									}
-								}
-
-								//copy index settings
-								if c.CopyIndexSettings {
-									tempIndexSettings = ((*sourceIndexSettings)[name]).(map[string]interface{})
-								}
-
-								//check map elements
-								if _, ok := tempIndexSettings["settings"]; !ok {
-									tempIndexSettings["settings"] = map[string]interface{}{}
-								}
-
-								if _, ok := tempIndexSettings["settings"].(map[string]interface{})["index"]; !ok {
-									tempIndexSettings["settings"].(map[string]interface{})["index"] = map[string]interface{}{}
-								}
-
-								sourceIndexRefreshSettings[name] = ((*sourceIndexSettings)[name].(map[string]interface{}))["settings"].(map[string]interface{})["index"].(map[string]interface{})["refresh_interval"]
-
-								//set refresh_interval
-								tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["refresh_interval"] = -1
-								tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["number_of_replicas"] = 0
-
-								//clean up settings
-								delete(tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{}), "number_of_shards")
-
-								//copy indexsettings and mappings
-								if targetIndexExist {
-									log.Debug("update index with settings,", name, tempIndexSettings)
-									//override shard settings
-									if c.ShardsCount > 0 {
-										tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["number_of_shards"] = c.ShardsCount
-									}
-									err := migrator.TargetESAPI.UpdateIndexSettings(name, tempIndexSettings)
-									if err != nil {
-										log.Error(err)
-									}
-								} else {
-
-									//override shard settings
-									if c.ShardsCount > 0 {
-										tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["number_of_shards"] = c.ShardsCount
-									}
-
-									log.Debug("create index with settings,", name, tempIndexSettings)
-									err := migrator.TargetESAPI.CreateIndex(name, tempIndexSettings)
-									if err != nil {
-										log.Error(err)
-									}
-
-								}
-
-							}
-
-							if c.CopyIndexMappings {
-
-								//if there is only one index and we specify the dest indexname
-								if c.SourceIndexNames != c.TargetIndexName && (len(c.TargetIndexName) > 0) && indexCount == 1 {
-									log.Debugf("only one index,so we can rewrite indexname, src:%v, dest:%v ,indexCount:%d", c.SourceIndexNames, c.TargetIndexName, indexCount)
-									(*sourceIndexMappings)[c.TargetIndexName] = (*sourceIndexMappings)[c.SourceIndexNames]
-									delete(*sourceIndexMappings, c.SourceIndexNames)
-									log.Debug(sourceIndexMappings)
-								}
-
-								for name, mapping := range *sourceIndexMappings {
-									err := migrator.TargetESAPI.UpdateIndexMapping(name, mapping.(map[string]interface{})["mappings"].(map[string]interface{}))
-									if err != nil {
-										log.Error(err)
-									}
-								}
-							}
-
-							log.Info("settings/mappings migration finished.")
-						}
-
-					} else {
-						log.Error("index not exists,", c.SourceIndexNames)
-						return
-					}
-
-					defer migrator.recoveryIndexSettings(sourceIndexRefreshSettings)
-				}
-
+	jobs := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	for i, jobConfig := range configs {
+		jobs <- struct{}{}
+		if ctx.Err() != nil {
+			errsLock.Lock()
+			errs = append(errs, migrate.ErrInterrupted)
+			errsLock.Unlock()
+			break
+		}
+		wg.Add(1)
+		go func(i int, jobConfig *migrate.Config) {
+			defer func() {
+				<-jobs
+				wg.Done()
+			}()
+
+			if len(configs) > 1 {
+				log.Infof("start job %d of %d, %s", i+1, len(configs), jobConfig.SourceIndexNames)
 			}
-
-			log.Info("start data migration..")
-
-			//start es bulk thread
-			if len(c.TargetEs) > 0 {
-				log.Debug("start es bulk workers")
-				outputBar.Prefix("Bulk")
-				var docCount int
-				wg.Add(c.Workers)
-				for i := 0; i < c.Workers; i++ {
-					go migrator.NewBulkWorker(&docCount, outputBar, &wg)
-				}
-			} else if len(c.DumpOutFile) > 0 {
-				// start file write
-				outputBar.Prefix("Write")
-				wg.Add(1)
-				go migrator.NewFileDumpWorker(outputBar, &wg)
+			migrator := migrators[i]
+			// a signal stops the migrator, requests in flight are not aborted
+			_, err := migrator.Run(context.Background())
+			if err == nil {
+				plans[i] = migrator.Plan
+				finished[i] = true
 			}
-
-			wg.Wait()
-
-			if showBar {
-
-				outputBar.Finish()
-				// close pool
-				pool.Stop()
-
+			if err != nil {
+				log.Errorf("job %d failed, %v", i+1, err)
+				errsLock.Lock()
+				errs = append(errs, err)
+				errsLock.Unlock()
+			} else if len(configs) > 1 {
+				log.Infof("job %d of %d finished, %s", i+1, len(configs), jobConfig.SourceIndexNames)
 			}
-		}
-
+		}(i, jobConfig)
 	}
-
-	log.Info("data migration finished.")
-}
-
-func (c *Migrator) recoveryIndexSettings(sourceIndexRefreshSettings map[string]interface{}) {
-	//update replica and refresh_interval
-	for name, interval := range sourceIndexRefreshSettings {
-		tempIndexSettings := getEmptyIndexSettings()
-		tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["refresh_interval"] = interval
-		//tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["number_of_replicas"] = 1
-		c.TargetESAPI.UpdateIndexSettings(name, tempIndexSettings)
-		if c.Config.Refresh {
-			c.TargetESAPI.Refresh(name)
+	wg.Wait()
+	progress.Stop()
+
+	if ctx.Err() != nil && len(c.CheckpointFile) > 0 {
+		checkpoint := migrate.NewCheckpoint(context.Cause(ctx), configs, finished, metrics.Status())
+		if err := checkpoint.WriteFile(c.CheckpointFile); err != nil {
+			log.Error(err)
+		} else {
+			log.Infof("checkpoint saved to %s", c.CheckpointFile)
 		}
 	}
-}
 
-func (c *Migrator) ClusterVersion(host string, auth *Auth, proxy string) (*ClusterVersion, []error) {
-
-	url := fmt.Sprint(host)
-	resp, body, errs := Get(url, auth, proxy)
-
-	if resp != nil && resp.Body != nil {
-		io.Copy(io.Discard, resp.Body)
-		defer resp.Body.Close()
-	}
-
-	if errs != nil {
-		log.Error(errs)
-		return nil, errs
+	if c.DryRun {
+		migrate.PrintPlans(os.Stdout, plans, c.PlanFormat)
+		if len(c.PlanFile) > 0 {
+			if err := migrate.WritePlans(c.PlanFile, plans, c.PlanFormat); err != nil {
+				log.Error(err)
+			}
+		}
+		log.Flush()
+		if len(errs) > 0 {
+			os.Exit(migrate.ExitFatal)
+		}
+		os.Exit(migrate.ExitSuccess)
 	}
 
-	log.Debug(body)
-
-	version := &ClusterVersion{}
-	err := json.Unmarshal([]byte(body), version)
-
-	if err != nil {
-		log.Error(body, errs)
-		return nil, errs
+	report := metrics.Report(errors.Join(errs...))
+	report.Print(console)
+	if len(c.ReportFile) > 0 {
+		if err := report.WriteFile(c.ReportFile); err != nil {
+			log.Error(err)
+		}
 	}
-	return version, nil
+	log.Flush()
+	os.Exit(report.ExitCode)
 }
 
-func (c *Migrator) ClusterReady(api ESAPI) (*ClusterHealth, bool) {
-	health := api.ClusterHealth()
-
-	if !c.Config.WaitForGreen {
-		return health, true
-	}
-
-	if health.Status == "red" {
-		return health, false
-	}
-
-	if !c.Config.WaitForGreen && health.Status == "yellow" {
-		return health, true
-	}
-
-	if health.Status == "green" {
-		return health, true
+// inspect prints the cluster of every job and exits
+func inspect(configs []*migrate.Config) {
+	exitCode := migrate.ExitSuccess
+	for i, c := range configs {
+		if len(configs) > 1 {
+			fmt.Printf("\n# job %d\n", i+1)
+		}
+		info, err := migrate.Inspect(context.Background(), c)
+		if err != nil {
+			log.Error(err)
+			exitCode = migrate.ExitFatal
+			continue
+		}
+		info.Print(os.Stdout)
 	}
-
-	return health, false
+	log.Flush()
+	os.Exit(exitCode)
 }
@@ -0,0 +1,230 @@
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/cihub/seelog"
+)
+
+// targetIndexNames returns the indexes written on target, _all and patterns
+// are resolved on source like the scroll does. The indexes of a load are
+// known from -y or the header of the dump only.
+func (m *Migrator) targetIndexNames() ([]string, error) {
+	c := m.Config
+	if len(c.TargetIndexName) > 0 {
+		return []string{c.TargetIndexName}, nil
+	}
+	indexNames := c.SourceIndexNames
+	if !resolvedIndexNames(indexNames) {
+		if m.SourceESAPI == nil {
+			return nil, errors.New("the indexes written on target are not known, set --dest_index")
+		}
+		_, _, mappings, err := m.SourceESAPI.GetIndexMappings(c.CopyAllIndexes, indexNames)
+		if err != nil {
+			return nil, err
+		}
+		resolved := []string{}
+		for name := range *mappings {
+			if !excludedIndex(indexNames, name) {
+				resolved = append(resolved, name)
+			}
+		}
+		indexNames = strings.Join(resolved, ",")
+	}
+	if len(indexNames) == 0 {
+		return nil, fmt.Errorf("no indexes of %s on source", c.SourceIndexNames)
+	}
+
+	names := strings.Split(indexNames, ",")
+	if c.Mode == "snapshot" && len(c.RenamePattern) > 0 {
+		for i, name := range names {
+			names[i] = m.restoredName(name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// resolvedIndexNames tells if the index names are names only, no _all,
+// patterns or exclusions
+func resolvedIndexNames(indexNames string) bool {
+	for _, name := range strings.Split(indexNames, ",") {
+		name = strings.TrimSpace(name)
+		if name == "_all" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, "*?") {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyCounts compares the documents matching the query on source with the
+// documents of the target indexes or alias
+func (m *Migrator) verifyCounts(target string) error {
+	c := m.Config
+	expected, err := m.SourceESAPI.Count(c.SourceIndexNames, c.Query)
+	if err != nil {
+		return err
+	}
+	if c.RegenerateID {
+		expected *= c.RepeatOutputTimes
+	}
+
+	actual, err := m.TargetESAPI.Count(target, "")
+	if err != nil {
+		return err
+	}
+	if actual != expected {
+		return fmt.Errorf("%s has %d documents on target, expected %d", target, actual, expected)
+	}
+	log.Infof("verified %d documents in %s", actual, target)
+	return nil
+}
+
+// verifyIndices refreshes the target indexes and compares their documents
+// with source
+func (m *Migrator) verifyIndices(indices []string) error {
+	for _, name := range indices {
+		m.TargetESAPI.Refresh(name)
+	}
+	return m.verifyCounts(strings.Join(indices, ","))
+}
+
+// aliasActions adds the alias to the indexes of add and removes it from the
+// indexes of remove in one request
+func aliasActions(alias string, add []string, remove []string) []map[string]interface{} {
+	actions := []map[string]interface{}{}
+	for _, name := range remove {
+		actions = append(actions, map[string]interface{}{"remove": map[string]interface{}{"index": name, "alias": alias}})
+	}
+	for _, name := range add {
+		actions = append(actions, map[string]interface{}{"add": map[string]interface{}{"index": name, "alias": alias}})
+	}
+	return actions
+}
+
+// cutoverAlias moves the alias from the indexes it points to onto the
+// migrated indexes. With --verify the document counts are checked before the
+// switch and through the alias after it, the switch is rolled back if the
+// alias doesn't serve the migrated documents.
+func (m *Migrator) cutoverAlias(indices []string) error {
+	c := m.Config
+	alias := c.Alias
+
+	if c.Verify && !c.DryRun {
+		if err := m.verifyIndices(indices); err != nil {
+			return fmt.Errorf("verification failed, alias %s was not moved: %v", alias, err)
+		}
+	}
+
+	current, err := m.TargetESAPI.GetAliases(alias)
+	if err != nil {
+		return err
+	}
+
+	isNew := map[string]bool{}
+	for _, name := range indices {
+		isNew[name] = true
+	}
+	isCurrent := map[string]bool{}
+	oldIndices := []string{}
+	for _, name := range current {
+		isCurrent[name] = true
+		if !isNew[name] {
+			oldIndices = append(oldIndices, name)
+		}
+	}
+	addIndices := []string{}
+	for _, name := range indices {
+		if !isCurrent[name] {
+			addIndices = append(addIndices, name)
+		}
+	}
+
+	if len(addIndices) == 0 && len(oldIndices) == 0 {
+		log.Infof("alias %s already points to %s", alias, strings.Join(indices, ","))
+		return nil
+	}
+
+	// deleting the old indexes is confirmed like --force before the switch,
+	// protected ones are kept
+	deleted := []string{}
+	if c.OldIndexAction == "delete" {
+		for _, name := range oldIndices {
+			if pattern, ok := protectedPattern(c.ProtectedIndices, name); ok {
+				log.Warnf("old index %s is protected by pattern %s, not deleted", name, pattern)
+				continue
+			}
+			deleted = append(deleted, name)
+		}
+	}
+	if len(deleted) > 0 && !c.AssumeYes && !c.DryRun {
+		question := fmt.Sprintf("move alias %s and delete %d old index(es) on %s: %s?", alias, len(deleted), c.TargetEs, strings.Join(deleted, ", "))
+		ok, err := confirm(question)
+		if err != nil {
+			return fmt.Errorf("--old_index_action=delete needs confirmation, alias %s was not moved: %v", alias, err)
+		}
+		if !ok {
+			return fmt.Errorf("deleting old indexes was not confirmed, alias %s was not moved", alias)
+		}
+	}
+
+	log.Infof("move alias %s from [%s] to [%s]", alias, strings.Join(current, ","), strings.Join(indices, ","))
+	if err := m.TargetESAPI.UpdateAliases(aliasActions(alias, addIndices, oldIndices)); err != nil {
+		return err
+	}
+
+	if !c.DryRun {
+		if err := m.checkAlias(alias, indices); err != nil {
+			log.Errorf("alias %s check failed, rolling back: %v", alias, err)
+			if rollbackErr := m.TargetESAPI.UpdateAliases(aliasActions(alias, oldIndices, addIndices)); rollbackErr != nil {
+				return fmt.Errorf("%v, rollback of alias %s failed: %v", err, alias, rollbackErr)
+			}
+			return fmt.Errorf("alias %s was rolled back to [%s]: %v", alias, strings.Join(current, ","), err)
+		}
+	}
+
+	// the alias was moved, failures to close or delete an old index fail the
+	// migration so that they show in the report
+	failed := []string{}
+	if c.OldIndexAction == "close" {
+		for _, name := range oldIndices {
+			log.Infof("close old index %s", name)
+			if err := m.TargetESAPI.CloseIndex(name); err != nil {
+				log.Error(err)
+				failed = append(failed, fmt.Sprintf("close %s: %v", name, err))
+			}
+		}
+	}
+	for _, name := range deleted {
+		log.Infof("delete old index %s", name)
+		if err := m.TargetESAPI.DeleteIndex(name); err != nil {
+			log.Error(err)
+			failed = append(failed, fmt.Sprintf("delete %s: %v", name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("alias %s was moved, but old indexes failed: %s", alias, strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// checkAlias makes sure the alias resolves to exactly the indexes and serves
+// all documents when verifying
+func (m *Migrator) checkAlias(alias string, indices []string) error {
+	current, err := m.TargetESAPI.GetAliases(alias)
+	if err != nil {
+		return err
+	}
+	expected := append([]string{}, indices...)
+	sort.Strings(expected)
+	if strings.Join(current, ",") != strings.Join(expected, ",") {
+		return fmt.Errorf("alias points to [%s]", strings.Join(current, ","))
+	}
+	if m.Config.Verify {
+		return m.verifyCounts(alias)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb"
+
+	log "github.com/cihub/seelog"
+)
+
+// NewBulkWorker writes the documents of the queue into target until the
+// queue is closed and drained, retries of rejected documents stop once ctx
+// is done. It fails when a bulk still fails after --bulk_retries.
+func (c *Migrator) NewBulkWorker(ctx context.Context, pb *pb.ProgressBar) error {
+
+	log.Debug("start es bulk worker")
+
+	bulkItemSize := 0
+	mainBuf := bytes.Buffer{}
+	docBuf := bytes.Buffer{}
+	docEnc := json.NewEncoder(&docBuf)
+	mappedIndices := map[string]bool{}
+	// documents of the buffer, acked once the bulk is answered
+	pending := []*Hit{}
+	var err error
+
+	idleDuration := 5 * time.Second
+	idleTimeout := time.NewTimer(idleDuration)
+	defer idleTimeout.Stop()
+
+READ_DOCS:
+	for {
+		idleTimeout.Reset(idleDuration)
+		select {
+		case hit, open := <-c.Docs.C():
+			// if channel is closed flush and gtfo
+			if !open {
+				goto WORKER_DONE
+			}
+			log.Trace("read doc from queue, ", hit.Index, "/", hit.Id)
+
+			// sanity check, documents without _id get one from target
+			if len(hit.Index) == 0 || len(hit.Source) == 0 {
+				log.Errorf("invalid document without _index or _source, index: %q, id: %q", hit.Index, hit.Id)
+				c.Metrics.AddSkipped(hit.Index, 1)
+				c.Docs.Done(hit)
+				hit.ack(true)
+				continue
+			}
+			if !c.transform(hit) {
+				c.Metrics.AddSkipped(hit.Index, 1)
+				c.Docs.Done(hit)
+				hit.ack(true)
+				continue
+			}
+
+			doc := Document{
+				Index:   hit.Index,
+				Type:    hit.Type,
+				Id:      hit.Id,
+				Routing: hit.Routing,
+			}
+
+			if c.Config.TargetIndexName != "" {
+				doc.Index = c.Config.TargetIndexName
+				if !mappedIndices[hit.Index] {
+					mappedIndices[hit.Index] = true
+					c.Metrics.MapIndex(doc.Index, hit.Index)
+				}
+			}
+
+			if c.Config.OverrideTypeName != "" {
+				doc.Type = c.Config.OverrideTypeName
+			}
+
+			if c.Config.RegenerateID {
+				doc.Id = ""
+			}
+
+			// the raw _source is written as is, it is only decoded to rename fields
+			source := []byte(hit.Source)
+			if c.Config.RenameFields != "" {
+				source, err = renameFields(source, hit.Type, c.Config.RenameFields)
+				if err != nil {
+					c.reportError(fmt.Errorf("failed to rename fields of document [%s/%s]: %v", hit.Index, hit.Id, err))
+					c.Metrics.AddSkipped(hit.Index, 1)
+					c.Docs.Done(hit)
+					hit.ack(true)
+					continue
+				}
+			}
+
+			// encode the action line and append the _source for a bulk request
+			post := map[string]Document{
+				"index": doc,
+			}
+			if err = docEnc.Encode(post); err != nil {
+				log.Error(err)
+			}
+			// a pretty printed _source would break the lines of the bulk request
+			if bytes.IndexByte(source, '\n') >= 0 {
+				err = json.Compact(&docBuf, source)
+			} else {
+				_, err = docBuf.Write(source)
+			}
+			c.Docs.Done(hit)
+			if err != nil {
+				c.reportError(fmt.Errorf("invalid _source of document [%s/%s]: %v", hit.Index, hit.Id, err))
+				c.Metrics.AddSkipped(hit.Index, 1)
+				docBuf.Reset()
+				hit.ack(true)
+				continue
+			}
+			docBuf.WriteByte('\n')
+			pending = append(pending, hit)
+
+			// append the doc to the main buffer
+			mainBuf.Write(docBuf.Bytes())
+			// reset for next document
+			bulkItemSize++
+			docBuf.Reset()
+
+			// if we approach the bulk size limit, flush to es and reset mainBuf
+			if mainBuf.Len()+docBuf.Len() > c.Throttle.BulkSize() {
+				goto CLEAN_BUFFER
+			}
+
+		case <-idleTimeout.C:
+			log.Debug("5s no message input")
+			goto CLEAN_BUFFER
+		}
+
+		goto READ_DOCS
+
+	CLEAN_BUFFER:
+		written, err := c.bulk(ctx, &mainBuf, bulkItemSize)
+		ackHits(pending, written)
+		pending = pending[:0]
+		if err != nil {
+			return err
+		}
+		log.Trace("clean buffer, and execute bulk insert")
+		pb.Add(bulkItemSize)
+		bulkItemSize = 0
+		if c.Config.SleepSecondsAfterEachBulk > 0 {
+			time.Sleep(time.Duration(c.Config.SleepSecondsAfterEachBulk) * time.Second)
+		}
+	}
+WORKER_DONE:
+	if docBuf.Len() > 0 {
+		mainBuf.Write(docBuf.Bytes())
+		bulkItemSize++
+	}
+	written, err := c.bulk(ctx, &mainBuf, bulkItemSize)
+	ackHits(pending, written)
+	if err != nil {
+		return err
+	}
+	log.Trace("bulk insert")
+	pb.Add(bulkItemSize)
+	return nil
+}
+
+// renameFields renames fields of the _source, comma separated old:new pairs,
+// _type as old name copies the type of the document into the new field
+func renameFields(source []byte, typeName string, renames string) ([]byte, error) {
+	fields := map[string]interface{}{}
+	if err := DecodeJsonBytes(source, &fields); err != nil {
+		return nil, err
+	}
+	for _, i := range strings.Split(renames, ",") {
+		fvs := strings.Split(i, ":")
+		if len(fvs) != 2 {
+			return nil, fmt.Errorf("invalid rename %q", i)
+		}
+		oldField := strings.TrimSpace(fvs[0])
+		newField := strings.TrimSpace(fvs[1])
+		if oldField == "_type" {
+			fields[newField] = typeName
+		} else {
+			v := fields[oldField]
+			fields[newField] = v
+			delete(fields, oldField)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// ackHits tells the inputs of the documents whether they were written, by
+// the results of their bulk in the same order
+func ackHits(hits []*Hit, written []bool) {
+	for i, hit := range hits {
+		hit.ack(i < len(written) && written[i])
+	}
+}
+
+// bulk sends the buffer to target, failed requests and documents rejected
+// with 429 are retried until ctx is done and every result is fed back into
+// the throughput controller. It returns whether each document of the buffer
+// was written, and an error once the retries are exhausted. Documents
+// refused for other reasons are counted as failed but don't fail the bulk.
+func (c *Migrator) bulk(ctx context.Context, data *bytes.Buffer, docCount int) ([]bool, error) {
+	written := make([]bool, docCount)
+	if data.Len() == 0 {
+		return written, nil
+	}
+	// the documents of the payload by their position in the buffer
+	positions := make([]int, docCount)
+	for i := range positions {
+		positions[i] = i
+	}
+
+	if err := c.DocLimiter.Wait(ctx, docCount); err != nil {
+		return written, err
+	}
+	if err := c.ByteLimiter.Wait(ctx, data.Len()); err != nil {
+		return written, err
+	}
+
+	// keep a copy of the payload, the api resets the buffer
+	payload := make([]byte, data.Len())
+	copy(payload, data.Bytes())
+
+	for attempt := 0; ; attempt++ {
+		c.Throttle.Acquire()
+		start := time.Now()
+		response, err := c.TargetESAPI.Bulk(data)
+		latency := time.Since(start)
+		c.Metrics.ObserveBulkLatency(latency)
+
+		final := attempt >= c.Config.BulkRetries
+		rejected := 0
+		var retry []byte
+		var retried []int
+		var failure error
+		// the request failed as a whole, all documents are sent again
+		resend := false
+		if err != nil || response == nil {
+			c.Metrics.AddBulkError(0, docCount)
+			failure = fmt.Errorf("bulk request of %d documents failed: %v", docCount, err)
+			resend = true
+		} else if response.Status >= 300 {
+			c.Metrics.AddBulkError(response.Status, docCount)
+			failure = fmt.Errorf("bulk request of %d documents failed with status %d", docCount, response.Status)
+			if response.Status == http.StatusTooManyRequests {
+				rejected = docCount
+			}
+			resend = true
+		} else {
+			c.recordBulkItems(response, final)
+			for i, ok := range indexedBulkItems(response) {
+				if ok && i < len(positions) {
+					written[positions[i]] = true
+				}
+			}
+			if response.Errors && !final {
+				retry, retried = rejectedBulkItems(payload, response)
+				rejected = len(retried)
+			} else if response.Errors {
+				if _, left := rejectedBulkItems(payload, response); len(left) > 0 {
+					failure = fmt.Errorf("%d documents were still rejected by target", len(left))
+				}
+			}
+		}
+		c.Throttle.Release(latency, rejected)
+
+		if failure != nil && final {
+			if resend {
+				c.recordFailedPayload(payload)
+			}
+			return written, fmt.Errorf("%v, giving up after %d attempts", failure, attempt+1)
+		}
+		if !resend && rejected == 0 {
+			return written, nil
+		}
+
+		backoff := c.Throttle.Backoff(attempt)
+		if failure != nil {
+			log.Warnf("%v, retry in %v", failure, backoff)
+		} else {
+			log.Debugf("%d documents were rejected by target, retry in %v", rejected, backoff)
+		}
+		if !resend {
+			// only the rejected documents are sent again
+			for i := range retried {
+				retried[i] = positions[retried[i]]
+			}
+			positions = retried
+			payload = retry
+			docCount = rejected
+		}
+		if err := sleepContext(ctx, backoff); err != nil {
+			c.reportError(fmt.Errorf("retry of %d documents stopped: %v", docCount, err))
+			c.recordFailedPayload(payload)
+			return written, nil
+		}
+		data.Reset()
+		data.Write(payload)
+	}
+}
+
+// indexedBulkItems tells for each action of the bulk response whether it
+// succeeded
+func indexedBulkItems(response *BulkResponse) []bool {
+	indexed := make([]bool, 0, len(response.Items))
+	for _, item := range response.Items {
+		for _, action := range item {
+			indexed = append(indexed, action.Status < 300)
+		}
+	}
+	return indexed
+}
+
+// rejectedBulkItems picks the actions rejected with 429 out of the bulk
+// payload, with their positions in it
+func rejectedBulkItems(payload []byte, response *BulkResponse) ([]byte, []int) {
+	lines := bytes.Split(bytes.TrimRight(payload, "\n"), []byte("\n"))
+	retry := bytes.Buffer{}
+	rejected := []int{}
+	line := 0
+	for i, item := range response.Items {
+		for op, action := range item {
+			size := 2
+			if op == "delete" {
+				size = 1
+			}
+			if line+size > len(lines) {
+				return retry.Bytes(), rejected
+			}
+			if action.Status == http.StatusTooManyRequests {
+				for _, l := range lines[line : line+size] {
+					retry.Write(l)
+					retry.WriteByte('\n')
+				}
+				rejected = append(rejected, i)
+			}
+			line += size
+		}
+	}
+	return retry.Bytes(), rejected
+}
+
+// recordBulkItems counts indexed and failed documents of a bulk response,
+// rejected documents only count as failed when they won't be retried
+func (c *Migrator) recordBulkItems(response *BulkResponse, final bool) {
+	for _, item := range response.Items {
+		for op, action := range item {
+			if action.Status < 300 {
+				c.Metrics.AddBulked(action.Index, 1)
+				continue
+			}
+			c.Metrics.AddBulkError(action.Status, 1)
+			if action.Status != http.StatusTooManyRequests || final {
+				c.reportError(fmt.Errorf("failed to %s document [%s/%s]: %v", op, action.Index, action.Id, action.Error))
+				c.Metrics.AddFailed(action.Index, 1)
+			}
+		}
+	}
+}
+
+// recordFailedPayload counts every document of a failed bulk request as
+// failed, by the index in its action line
+func (c *Migrator) recordFailedPayload(payload []byte) {
+	lines := bytes.Split(bytes.TrimRight(payload, "\n"), []byte("\n"))
+	for i := 0; i < len(lines); i += 2 {
+		action := map[string]Document{}
+		if err := json.Unmarshal(lines[i], &action); err != nil {
+			continue
+		}
+		for _, doc := range action {
+			c.Metrics.AddFailed(doc.Index, 1)
+		}
+	}
+}
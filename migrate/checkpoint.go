@@ -0,0 +1,62 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+)
+
+// ErrInterrupted is returned by a migration stopped by a signal, after the
+// documents read so far were flushed
+var ErrInterrupted = errors.New("migration interrupted")
+
+type CheckpointJob struct {
+	Source      string `json:"source,omitempty"`
+	InputFile   string `json:"input_file,omitempty"`
+	Indices     string `json:"indices,omitempty"`
+	Query       string `json:"query,omitempty"`
+	Target      string `json:"target,omitempty"`
+	TargetIndex string `json:"target_index,omitempty"`
+	OutputFile  string `json:"output_file,omitempty"`
+	Finished    bool   `json:"finished"`
+}
+
+// Checkpoint is the progress of an interrupted run
+type Checkpoint struct {
+	Reason      string           `json:"reason"`
+	Interrupted time.Time        `json:"interrupted"`
+	Jobs        []CheckpointJob  `json:"jobs"`
+	Status      *MigrationStatus `json:"status"`
+}
+
+func NewCheckpoint(reason error, configs []*Config, finished []bool, status *MigrationStatus) *Checkpoint {
+	checkpoint := &Checkpoint{
+		Interrupted: time.Now(),
+		Status:      status,
+	}
+	if reason != nil {
+		checkpoint.Reason = reason.Error()
+	}
+	for i, c := range configs {
+		checkpoint.Jobs = append(checkpoint.Jobs, CheckpointJob{
+			Source:      c.SourceEs,
+			InputFile:   c.DumpInputFile,
+			Indices:     c.SourceIndexNames,
+			Query:       c.Query,
+			Target:      c.TargetEs,
+			TargetIndex: c.TargetIndexName,
+			OutputFile:  c.DumpOutFile,
+			Finished:    finished[i],
+		})
+	}
+	return checkpoint
+}
+
+func (c *Checkpoint) WriteFile(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
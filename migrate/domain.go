@@ -0,0 +1,394 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+type Indexes map[string]interface{}
+
+type Document struct {
+	Index   string `json:"_index,omitempty"`
+	Type    string `json:"_type,omitempty"`
+	Id      string `json:"_id,omitempty"`
+	Routing string `json:"routing,omitempty"` //after 6, only `routing` was supported
+}
+
+// Hit is a document read from source or from a dump file, the _source is
+// kept raw and written into target as is
+type Hit struct {
+	Index   string          `json:"_index"`
+	Type    string          `json:"_type,omitempty"`
+	Id      string          `json:"_id"`
+	Routing string          `json:"_routing,omitempty"`
+	Source  json.RawMessage `json:"_source"`
+
+	// bytes held in the queue, a transform may change the _source
+	size int64
+	// called by the writer once the document is written or failed, inputs
+	// that commit what was read set it
+	done func(written bool)
+}
+
+// ack tells the input of the document whether it was written
+func (h *Hit) ack(written bool) {
+	if h.done != nil {
+		h.done(written)
+	}
+}
+
+type Scroll struct {
+	Took     int         `json:"took,omitempty"`
+	ScrollId string      `json:"_scroll_id,omitempty"`
+	TimedOut bool        `json:"timed_out,omitempty"`
+	Error    interface{} `json:"error,omitempty"`
+	Status   int         `json:"status,omitempty"`
+	Hits     struct {
+		MaxScore float32 `json:"max_score,omitempty"`
+		Total    int     `json:"total,omitempty"`
+		Docs     []Hit   `json:"hits,omitempty"`
+	} `json:"hits"`
+	Shards struct {
+		Total      int `json:"total,omitempty"`
+		Successful int `json:"successful,omitempty"`
+		Skipped    int `json:"skipped,omitempty"`
+		Failed     int `json:"failed,omitempty"`
+		Failures   []struct {
+			Shard  int         `json:"shard,omitempty"`
+			Index  string      `json:"index,omitempty"`
+			Status int         `json:"status,omitempty"`
+			Reason interface{} `json:"reason,omitempty"`
+		} `json:"failures,omitempty"`
+	} `json:"_shards,omitempty"`
+}
+
+type ScrollV7 struct {
+	Scroll
+	Hits struct {
+		MaxScore float32 `json:"max_score,omitempty"`
+		Total    struct {
+			Value    int    `json:"value,omitempty"`
+			Relation string `json:"relation,omitempty"`
+		} `json:"total,omitempty"`
+		Docs []Hit `json:"hits,omitempty"`
+	} `json:"hits"`
+}
+
+type ClusterVersion struct {
+	Name        string `json:"name,omitempty"`
+	ClusterName string `json:"cluster_name,omitempty"`
+	Version     struct {
+		Number        string `json:"number,omitempty"`
+		LuceneVersion string `json:"lucene_version,omitempty"`
+	} `json:"version,omitempty"`
+}
+
+type ClusterHealth struct {
+	Name     string `json:"cluster_name,omitempty"`
+	Status   string `json:"status,omitempty"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+}
+
+// {"took":23,"errors":true,"items":[{"create":{"_index":"mybank3","_type":"my_doc2","_id":"AWz8rlgUkzP-cujdA_Fv","status":409,"error":{"type":"version_conflict_engine_exception","reason":"[AWz8rlgUkzP-cujdA_Fv]: version conflict, document already exists (current version [1])","index_uuid":"w9JZbJkfSEWBI-uluWorgw","shard":"0","index":"mybank3"}}},{"create":{"_index":"mybank3","_type":"my_doc4","_id":"AWz8rpF2kzP-cujdA_Fx","status":400,"error":{"type":"illegal_argument_exception","reason":"Rejecting mapping update to [mybank3] as the final mapping would have more than 1 type: [my_doc2, my_doc4]"}}},{"create":{"_index":"mybank3","_type":"my_doc1","_id":"AWz8rjpJkzP-cujdA_Fu","status":400,"error":{"type":"illegal_argument_exception","reason":"Rejecting mapping update to [mybank3] as the final mapping would have more than 1 type: [my_doc2, my_doc1]"}}},{"create":{"_index":"mybank3","_type":"my_doc3","_id":"AWz8rnbckzP-cujdA_Fw","status":400,"error":{"type":"illegal_argument_exception","reason":"Rejecting mapping update to [mybank3] as the final mapping would have more than 1 type: [my_doc2, my_doc3]"}}},{"create":{"_index":"mybank3","_type":"my_doc5","_id":"AWz8rrsEkzP-cujdA_Fy","status":400,"error":{"type":"illegal_argument_exception","reason":"Rejecting mapping update to [mybank3] as the final mapping would have more than 1 type: [my_doc2, my_doc5]"}}},{"create":{"_index":"mybank3","_type":"doc","_id":"3","status":400,"error":{"type":"illegal_argument_exception","reason":"Rejecting mapping update to [mybank3] as the final mapping would have more than 1 type: [my_doc2, doc]"}}}]}
+type BulkResponse struct {
+	Took   int                 `json:"took,omitempty"`
+	Errors bool                `json:"errors,omitempty"`
+	Items  []map[string]Action `json:"items,omitempty"`
+	Status int                 `json:"status,omitempty"` //only set when the whole request failed, ie: 429
+	Error  interface{}         `json:"error,omitempty"`
+}
+
+type Action struct {
+	Index  string      `json:"_index,omitempty"`
+	Type   string      `json:"_type,omitempty"`
+	Id     string      `json:"_id,omitempty"`
+	Status int         `json:"status,omitempty"`
+	Error  interface{} `json:"error,omitempty"`
+}
+
+// {"nodes":{"x1u3gXJ_SxmD9MtVoc9B7g":{"thread_pool":{"write":{"threads":8,"queue":0,"active":0,"rejected":0}}}}}
+type NodesThreadPoolStats struct {
+	Nodes map[string]struct {
+		ThreadPool map[string]struct {
+			Threads  int `json:"threads,omitempty"`
+			Queue    int `json:"queue,omitempty"`
+			Active   int `json:"active,omitempty"`
+			Rejected int `json:"rejected,omitempty"`
+		} `json:"thread_pool,omitempty"`
+	} `json:"nodes,omitempty"`
+}
+
+// {"indices":{"twitter":{"primaries":{"docs":{"count":2},"store":{"size_in_bytes":8622}}}}}
+type IndicesStats struct {
+	Indices map[string]struct {
+		Primaries struct {
+			Docs struct {
+				Count int64 `json:"count,omitempty"`
+			} `json:"docs,omitempty"`
+			Store struct {
+				SizeInBytes int64 `json:"size_in_bytes,omitempty"`
+			} `json:"store,omitempty"`
+		} `json:"primaries,omitempty"`
+	} `json:"indices,omitempty"`
+}
+
+type IndexStats struct {
+	Docs       int64
+	StoreBytes int64
+}
+
+type AcknowledgedResponse struct {
+	Acknowledged bool `json:"acknowledged"`
+}
+
+type SnapshotResponse struct {
+	Snapshot struct {
+		Snapshot string `json:"snapshot"`
+		State    string `json:"state"`
+	} `json:"snapshot"`
+}
+
+// SnapshotStatus is the progress of a snapshot, the state is one of INIT,
+// STARTED, SUCCESS, FAILED or ABORTED
+type SnapshotStatus struct {
+	State          string
+	TotalBytes     int64
+	ProcessedBytes int64
+}
+
+// {"snapshots":[{"state":"STARTED","stats":{"total":{"size_in_bytes":1024},"processed":{"size_in_bytes":512}}}]},
+// before 7.x the sizes are stats.total_size_in_bytes and stats.processed_size_in_bytes
+type SnapshotStatusResponse struct {
+	Snapshots []struct {
+		State string `json:"state"`
+		Stats struct {
+			TotalSizeInBytes     int64 `json:"total_size_in_bytes"`
+			ProcessedSizeInBytes int64 `json:"processed_size_in_bytes"`
+			Total                struct {
+				SizeInBytes int64 `json:"size_in_bytes"`
+			} `json:"total"`
+			Processed struct {
+				SizeInBytes int64 `json:"size_in_bytes"`
+			} `json:"processed"`
+		} `json:"stats"`
+	} `json:"snapshots"`
+}
+
+// RecoveryStatus is the progress of the primary shards of restored indexes
+type RecoveryStatus struct {
+	Shards         int
+	DoneShards     int
+	TotalBytes     int64
+	RecoveredBytes int64
+}
+
+// {"orders":{"shards":[{"primary":true,"stage":"DONE","index":{"size":{"total_in_bytes":1024,"recovered_in_bytes":1024}}}]}}
+type RecoveryResponse map[string]struct {
+	Shards []struct {
+		Primary bool   `json:"primary"`
+		Stage   string `json:"stage"`
+		Index   struct {
+			Size struct {
+				TotalInBytes     int64 `json:"total_in_bytes"`
+				RecoveredInBytes int64 `json:"recovered_in_bytes"`
+			} `json:"size"`
+		} `json:"index"`
+	} `json:"shards"`
+}
+
+type ReindexResponse struct {
+	TimedOut bool          `json:"timed_out"`
+	Total    int           `json:"total"`
+	Created  int           `json:"created"`
+	Failures []interface{} `json:"failures"`
+}
+
+// {"completed":true,"task":{"status":{"total":100,"created":90,"updated":10}},"response":{"failures":[]}},
+// error is set when the task itself failed
+type TaskStatus struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status ReindexProgress `json:"status"`
+	} `json:"task"`
+	Response struct {
+		ReindexProgress
+		Failures []interface{} `json:"failures"`
+	} `json:"response"`
+	Error interface{} `json:"error"`
+}
+
+type ReindexProgress struct {
+	Total            int `json:"total"`
+	Created          int `json:"created"`
+	Updated          int `json:"updated"`
+	Deleted          int `json:"deleted"`
+	VersionConflicts int `json:"version_conflicts"`
+}
+
+// {"orders-v1":{"aliases":{"orders":{}}}}
+type AliasesResponse map[string]struct {
+	Aliases map[string]interface{} `json:"aliases"`
+}
+
+type CountResponse struct {
+	Count int `json:"count"`
+}
+
+// FieldStats is the stats aggregation of a numeric or date field, dates are
+// in epoch milliseconds
+type FieldStats struct {
+	Count int64   `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+}
+
+type FieldStatsResponse struct {
+	Aggregations struct {
+		Stats FieldStats `json:"stats"`
+	} `json:"aggregations"`
+}
+
+type Migrator struct {
+	FlushLock   sync.Mutex
+	Docs        *DocQueue
+	SourceESAPI ESAPI
+	TargetESAPI ESAPI
+	SourceAuth  *Auth
+	TargetAuth  *Auth
+	Config      *Config
+	Throttle    *ThroughputController
+	DocLimiter  *RateLimiter
+	ByteLimiter *RateLimiter
+	Metrics     *Metrics
+	Progress    *Progress
+	Plan        *Plan
+	Restore     *IndexRestore
+	Hooks       Hooks
+
+	stopLock    sync.Mutex
+	stopCause   error
+	stopReading context.CancelCauseFunc
+
+	// version of the source cluster
+	sourceVersion string
+	// stdin after the header of the dump was read
+	stdin io.Reader
+	// kafka clients of --input_topic and --output_topic, created from the
+	// config when not set
+	kafkaReader kafkaReader
+	kafkaWriter kafkaWriter
+}
+
+type Config struct {
+
+	// config options
+	ConfigFile          string `long:"config"  description:"load options from a yaml or toml file, ESM_* environment variables and command line options take precedence, ie: esm.yml"`
+	SourceEs            string `short:"s" long:"source"  description:"source elasticsearch instance, ie: http://localhost:9200"`
+	Query               string `short:"q" long:"query"  description:"query against source elasticsearch instance, filter data before migrate, ie: name:medcl"`
+	TargetEs            string `short:"d" long:"dest"    description:"destination elasticsearch instance, ie: http://localhost:9201"`
+	SourceEsAuthStr     string `short:"m" long:"source_auth"  description:"basic auth of source elasticsearch instance, ie: user:pass"`
+	TargetEsAuthStr     string `short:"n" long:"dest_auth"  description:"basic auth of target elasticsearch instance, ie: user:pass"`
+	DocBufferCount      int    `short:"c" long:"count"   description:"number of documents at a time: ie \"size\" in the scroll request" default:"10000"`
+	BufferCount         int    `long:"buffer_count"   description:"number of buffered documents in memory" default:"1000000"`
+	BufferMB            int    `long:"buffer_mb"      description:"size in MB of the documents buffered in memory, readers wait while the buffer is full" default:"256"`
+	Workers             int    `short:"w" long:"workers" description:"concurrency number for bulk workers" default:"1"`
+	BulkSizeInMB        int    `short:"b" long:"bulk_size" description:"bulk size in MB" default:"5"`
+	ScrollTime          string `short:"t" long:"time"    description:"scroll time" default:"10m"`
+	ScrollSliceSize     int    `long:"sliced_scroll_size"    description:"size of sliced scroll, to make it work, the size should be > 1" default:"1"`
+	RecreateIndex       bool   `short:"f" long:"force"   description:"delete destination index before copying"`
+	CopyAllIndexes      bool   `short:"a" long:"all"     description:"copy indexes starting with . and _"`
+	CopyIndexSettings   bool   `long:"copy_settings"          description:"copy index settings from source, or from the header of the input dump"`
+	CopyIndexMappings   bool   `long:"copy_mappings"          description:"copy index mappings from source, or from the header of the input dump, translated across major versions"`
+	ShardsCount         int    `long:"shards"            description:"set a number of shards on newly created indexes"`
+	SourceIndexNames    string `short:"x" long:"src_indexes" description:"indexes name to copy,support regex and comma separated list" default:"_all"`
+	TargetIndexName     string `short:"y" long:"dest_index" description:"indexes name to save, allow only one indexname, original indexname will be used if not specified" default:""`
+	OverrideTypeName    string `short:"u" long:"type_override" description:"override type name" default:""`
+	WaitForGreen        bool   `long:"green"             description:"wait for both hosts cluster status to be green before dump. otherwise yellow is okay"`
+	LogLevel            string `short:"v" long:"log"            description:"setting log level,options:trace,debug,info,warn,error"  default:"INFO"`
+	DumpOutFile         string `short:"o" long:"output_file"            description:"output documents of source index into local file, - for stdout, or into objects of s3://bucket/prefix" `
+	DumpInputFile       string `short:"i" long:"input_file"            description:"indexing from local dump file, - for stdin, or from the objects of s3://bucket/prefix" `
+	InputFileType       string `long:"input_file_type"                 description:"the data type of input file, csv and parquet rows are converted to the types of the target mapping, options: dump, json_line, json_array, log_line, csv, parquet" default:"dump" `
+	OutputFormat        string `long:"output_format"                   description:"format of output file, csv and parquet have a column per field of the source mapping, objects are flattened to dotted columns, options: dump, csv, parquet" default:"dump" choice:"dump" choice:"csv" choice:"parquet"`
+	SourceProxy         string `long:"source_proxy"            description:"set proxy to source http connections, ie: http://127.0.0.1:8080"`
+	TargetProxy         string `long:"dest_proxy"            description:"set proxy to target http connections, ie: http://127.0.0.1:8080"`
+	Refresh             bool   `long:"refresh"                 description:"refresh after migration finished"`
+	Fields              string `long:"fields"                 description:"filter source fields, comma separated, ie: col1,col2,col3,..." `
+	RenameFields        string `long:"rename"                 description:"rename source fields, comma separated, ie: _type:type, name:myname" `
+	LogstashEndpoint    string `short:"l"  long:"logstash_endpoint"    description:"target logstash tcp endpoint, ie: 127.0.0.1:5055" `
+	LogstashSecEndpoint bool   `long:"secured_logstash_endpoint"    description:"target logstash tcp endpoint was secured by TLS" `
+
+	RepeatOutputTimes         int  `long:"repeat_times"            description:"repeat the data from source N times to dest output, use align with parameter regenerate_id to amplify the data size "`
+	RegenerateID              bool `short:"r" long:"regenerate_id"   description:"regenerate id for documents, this will override the exist document id in data source"`
+	Compress                  bool `long:"compress"            description:"use gzip to compress traffic"`
+	SleepSecondsAfterEachBulk int  `short:"p" long:"sleep" description:"sleep N seconds after each bulk request" default:"-1"`
+
+	Adaptive        bool          `long:"adaptive"            description:"adjust bulk size and in-flight bulk requests based on target latency, rejections and write queue"`
+	MaxBulkSizeInMB int           `long:"max_bulk_size"       description:"upper limit of bulk size in MB for adaptive mode" default:"20"`
+	BulkLatency     time.Duration `long:"bulk_latency"        description:"target latency of a bulk request for adaptive mode" default:"2s"`
+	QueueThreshold  int           `long:"queue_threshold"     description:"shrink throughput when the target write thread pool queue exceeds this size, 0 to disable" default:"50"`
+	BulkRetries     int           `long:"bulk_retries"        description:"retry failed and rejected(429) bulk requests N times, then the migration fails" default:"5"`
+	ScrollRetries   int           `long:"scroll_retries"      description:"retry failed scroll requests N times with backoff before the slice fails" default:"5"`
+	MaxDocsPerSec   int           `long:"max_docs_per_sec"    description:"limit documents per second sent to target across all workers, 0 means unlimited"`
+	MaxBytesPerSec  int           `long:"max_bytes_per_sec"   description:"limit bytes per second sent to target across all workers, 0 means unlimited"`
+
+	Split      string `long:"split"         description:"split reading of source into scrolls per index, per shard or per range of --split_field, options: none, index, shard, range" default:"none" choice:"none" choice:"index" choice:"shard" choice:"range"`
+	SplitField string `long:"split_field"   description:"numeric or date field of source split into ranges by --split=range, ie: created_at"`
+	SplitCount int    `long:"split_count"   description:"number of ranges of --split=range" default:"8"`
+	Readers    int    `long:"readers"       description:"number of scrolls read in parallel, defaults to --sliced_scroll_size, or of parts of a local input file read in parallel"`
+
+	HttpListen     string `long:"http_listen"   description:"address of the http server exposing pprof, /metrics and /status, empty to disable" default:"0.0.0.0:6060"`
+	ReportFile     string `long:"report"        description:"write the migration summary as json into this file, ie: report.json"`
+	CheckpointFile string `long:"checkpoint"    description:"save the progress into this file when interrupted by a signal, empty to disable" default:"esm_checkpoint.json"`
+
+	IndexSettings string `long:"index_settings"   description:"override settings of target indexes, json, ie: {\"number_of_replicas\":1,\"refresh_interval\":\"30s\"}"`
+	ParallelJobs  int    `long:"parallel_jobs"    description:"number of jobs of the config file running in parallel" default:"1"`
+
+	DryRun     bool   `long:"dry_run"      description:"connect to both clusters and print the migration plan without writing anything"`
+	PlanFormat string `long:"plan_format"  description:"format of the dry run plan, options: text, json" default:"text" choice:"text" choice:"json"`
+	PlanFile   string `long:"plan_file"    description:"also save the dry run plan into this file"`
+
+	AssumeYes          bool   `long:"yes"                  description:"don't ask for confirmation before --force or --old_index_action=delete deletes target indexes"`
+	ProtectedIndices   string `long:"protected_indices"    description:"comma separated index patterns on target that --force never deletes" default:".*"`
+	Backup             string `long:"backup"               description:"backup target indexes before --force deletes them, options: none, snapshot, rename" default:"none" choice:"none" choice:"snapshot" choice:"rename"`
+	SnapshotRepository string `long:"snapshot_repository"  description:"snapshot repository of target used by --backup=snapshot, of both clusters used by --mode=snapshot"`
+
+	Mode              string `long:"mode"                description:"how documents are moved, scroll reads and bulk writes them, snapshot restores a snapshot of source on target, reindex_remote makes target pull them with _reindex, options: scroll, snapshot, reindex_remote" default:"scroll" choice:"scroll" choice:"snapshot" choice:"reindex_remote"`
+	SnapshotPath      string `long:"snapshot_path"       description:"register --snapshot_repository as fs repository at this path on both clusters for --mode=snapshot, the path must be shared and in path.repo, ie: /mnt/esm"`
+	RenamePattern     string `long:"rename_pattern"      description:"regex renaming the indexes restored by --mode=snapshot, ie: (.+)"`
+	RenameReplacement string `long:"rename_replacement"  description:"replacement of --rename_pattern, ie: restored_$1"`
+	RemoteHost        string `long:"remote_host"         description:"source as reached from target by --mode=reindex_remote, it must be in reindex.remote.whitelist of target, defaults to --source"`
+
+	Alias          string `long:"alias"              description:"after the migration, atomically move this alias of target to the migrated indexes, a load needs --dest_index unless it reads a dump with header, ie: orders"`
+	Verify         bool   `long:"verify"             description:"compare document counts of source and target after the migration, the job fails and the alias is not moved on mismatch"`
+	OldIndexAction string `long:"old_index_action"   description:"what to do with the indexes the alias was moved away from, options: keep, close, delete" default:"keep" choice:"keep" choice:"close" choice:"delete"`
+
+	GreenTimeout time.Duration `long:"green_timeout"   description:"after restoring replicas, wait up to this long for target indexes to become green, 0 to not wait"`
+
+	KafkaBrokers      string        `long:"kafka_brokers"        description:"comma separated kafka brokers of --input_topic and --output_topic, ie: localhost:9092"`
+	InputTopic        string        `long:"input_topic"          description:"index documents of these comma separated kafka topics, offsets are committed once the documents are written"`
+	OutputTopic       string        `long:"output_topic"         description:"write documents into kafka, {index} is replaced by the index of the document, ie: esm-{index}"`
+	KafkaGroup        string        `long:"kafka_group"          description:"consumer group of --input_topic" default:"esm"`
+	KafkaBatchSize    int           `long:"kafka_batch_size"     description:"number of documents produced to kafka at a time" default:"1000"`
+	KafkaBatchTimeout time.Duration `long:"kafka_batch_timeout"  description:"produce a partial batch after this long" default:"1s"`
+	KafkaCompression  string        `long:"kafka_compression"    description:"compression of produced messages, options: none, gzip, snappy, lz4, zstd" default:"none" choice:"none" choice:"gzip" choice:"snappy" choice:"lz4" choice:"zstd"`
+	KafkaIdleTimeout  time.Duration `long:"kafka_idle_timeout"   description:"stop consuming --input_topic when no message arrived for this long, 0 to consume until interrupted" default:"10s"`
+
+	S3Endpoint    string `long:"s3_endpoint"     description:"endpoint of the s3 compatible storage of s3://bucket/prefix input and output files, ie: http://127.0.0.1:9000 for minio" default:"https://s3.amazonaws.com"`
+	S3Region      string `long:"s3_region"       description:"region of the s3 buckets" default:"us-east-1"`
+	S3Auth        string `long:"s3_auth"         description:"access key and secret key of s3, ie: key:secret, defaults to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment or ~/.aws/credentials"`
+	S3ChunkSize   int    `long:"s3_chunk_size"   description:"size of the objects of an s3 output in MB, the dump is split into objects of this size" default:"1024"`
+	S3PartSize    int    `long:"s3_part_size"    description:"size of the parts uploaded and downloaded at a time in MB" default:"16"`
+	S3Concurrency int    `long:"s3_concurrency"  description:"number of parts uploaded or downloaded in parallel" default:"4"`
+
+	// set by the mappings and verify commands, indexes are prepared and
+	// verified without reading any document
+	SkipDocuments bool
+}
+
+type Auth struct {
+	User string
+	Pass string
+}
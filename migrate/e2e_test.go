@@ -0,0 +1,339 @@
+package migrate_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	log "github.com/cihub/seelog"
+	goflags "github.com/jessevdk/go-flags"
+	"github.com/raminhz90/esm/esmtest"
+	"github.com/raminhz90/esm/migrate"
+)
+
+// versions are the emulated versions of source and target
+var versions = []string{"1.7.6", "2.4.6", "5.6.16", "6.8.23", "7.10.2", "8.15.0", "9.1.0"}
+
+func TestMain(m *testing.M) {
+	log.ReplaceLogger(log.Disabled)
+	os.Exit(m.Run())
+}
+
+// newConfig returns the config of the command line arguments
+func newConfig(t *testing.T, args ...string) *migrate.Config {
+	t.Helper()
+	c := &migrate.Config{}
+	if _, err := goflags.ParseArgs(c, args); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func run(t *testing.T, c *migrate.Config) *migrate.Report {
+	t.Helper()
+	report, err := migrate.Run(context.Background(), c)
+	if err != nil {
+		t.Fatalf("migration failed: %v", err)
+	}
+	return report
+}
+
+// newSource returns a server of the version with the orders and logs
+// indexes, orders has a mapping and an alias
+func newSource(t *testing.T, version string) *esmtest.Server {
+	t.Helper()
+	source := esmtest.NewServer(version)
+	t.Cleanup(source.Close)
+	source.AddDocs("orders", 250)
+	source.AddDocs("logs", 30)
+
+	name := map[string]interface{}{"type": "text"}
+	if major(version) < 5 {
+		name = map[string]interface{}{"type": "string"}
+	}
+	orders := source.Index("orders")
+	orders.Mappings["properties"] = map[string]interface{}{"n": map[string]interface{}{"type": "long"}, "name": name}
+	orders.Aliases["orders_read"] = map[string]interface{}{}
+	return source
+}
+
+func major(version string) int {
+	var n int
+	fmt.Sscanf(version, "%d.", &n)
+	return n
+}
+
+func checkCount(t *testing.T, server *esmtest.Server, index string, expected int) {
+	t.Helper()
+	if count := server.Count(index); count != expected {
+		t.Errorf("%s of %s has %d documents, expected %d", index, server.Version, count, expected)
+	}
+}
+
+func checkAlias(t *testing.T, server *esmtest.Server, alias string, expected ...string) {
+	t.Helper()
+	if indices := server.Aliases(alias); !reflect.DeepEqual(indices, expected) {
+		t.Errorf("alias %s points to %v, expected %v", alias, indices, expected)
+	}
+}
+
+// checkNameMapping fails unless name is mapped as a string of the version
+func checkNameMapping(t *testing.T, server *esmtest.Server, index string) {
+	t.Helper()
+	mappings := server.Index(index).Mappings
+	if _, ok := mappings["properties"]; !ok {
+		for _, mapping := range mappings {
+			if typed, ok := mapping.(map[string]interface{}); ok {
+				mappings = typed
+			}
+		}
+	}
+	properties, _ := mappings["properties"].(map[string]interface{})
+	name, _ := properties["name"].(map[string]interface{})
+	expected := "text"
+	if major(server.Version) < 5 {
+		expected = "string"
+	}
+	if name["type"] != expected {
+		t.Errorf("name of %s of %s is mapped as %v, expected %s", index, server.Version, name["type"], expected)
+	}
+}
+
+func TestClusterToCluster(t *testing.T) {
+	for _, sourceVersion := range versions {
+		for _, targetVersion := range versions {
+			t.Run(sourceVersion+"_to_"+targetVersion, func(t *testing.T) {
+				source := newSource(t, sourceVersion)
+				target := esmtest.NewServer(targetVersion)
+				defer target.Close()
+
+				report := run(t, newConfig(t, "-s", source.URL, "-d", target.URL, "-x", "orders", "-y", "orders_v2",
+					"--copy_settings", "--copy_mappings", "--alias", "orders_live", "-c", "40", "-w", "2", "--readers", "2"))
+
+				if report.Total.Scrolled != 250 || report.Total.Written != 250 || report.Total.Failed != 0 {
+					t.Errorf("report counts %+v, expected 250 scrolled and written", report.Total)
+				}
+				checkCount(t, target, "orders_v2", 250)
+				checkNameMapping(t, target, "orders_v2")
+				checkAlias(t, target, "orders_live", "orders_v2")
+				if target.Index("logs") != nil {
+					t.Error("logs was copied, it was not selected")
+				}
+			})
+		}
+	}
+}
+
+// readDump returns the header and the document lines of the dump
+func readDump(t *testing.T, file string) (*migrate.DumpHeader, [][]byte) {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header *migrate.DumpHeader
+	docs := [][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := append([]byte{}, scanner.Bytes()...)
+		if header == nil && len(docs) == 0 && bytes.HasPrefix(line, []byte(`{"esm_dump":`)) {
+			wrapped := struct {
+				Header *migrate.DumpHeader `json:"esm_dump"`
+			}{}
+			if err := json.Unmarshal(line, &wrapped); err != nil {
+				t.Fatalf("invalid dump header: %v", err)
+			}
+			header = wrapped.Header
+			continue
+		}
+		docs = append(docs, line)
+	}
+	return header, docs
+}
+
+func TestClusterToFile(t *testing.T) {
+	for _, version := range versions {
+		t.Run(version, func(t *testing.T) {
+			source := newSource(t, version)
+			file := filepath.Join(t.TempDir(), "dump.json")
+
+			report := run(t, newConfig(t, "-s", source.URL, "-o", file, "-x", "*,-logs", "-c", "40"))
+
+			header, docs := readDump(t, file)
+			if len(docs) != 250 || report.Total.Written != 250 {
+				t.Errorf("dump has %d documents, %d written, expected 250", len(docs), report.Total.Written)
+			}
+			if header == nil {
+				t.Fatal("dump has no header")
+			}
+			if header.SourceVersion != version {
+				t.Errorf("header of source version %s, expected %s", header.SourceVersion, version)
+			}
+			if len(header.Indices) != 1 || header.Indices["orders"] == nil {
+				t.Fatalf("header has the indexes %v, expected orders only", header.Indices)
+			}
+			if _, ok := header.Indices["orders"].Aliases["orders_read"]; !ok {
+				t.Errorf("header has the aliases %v, expected orders_read", header.Indices["orders"].Aliases)
+			}
+		})
+	}
+}
+
+// dumpWithout dumps orders of a source failing the endpoint for missing
+// permissions
+func dumpWithout(t *testing.T, endpoint string) (*migrate.DumpHeader, [][]byte) {
+	t.Helper()
+	source := newSource(t, "7.10.2")
+	source.Fail(endpoint, 0, 403)
+	file := filepath.Join(t.TempDir(), "dump.json")
+	run(t, newConfig(t, "-s", source.URL, "-o", file, "-x", "orders"))
+	return readDump(t, file)
+}
+
+func TestClusterToFilePartialHeader(t *testing.T) {
+	header, docs := dumpWithout(t, "_alias")
+	if len(docs) != 250 {
+		t.Errorf("dump has %d documents, expected 250", len(docs))
+	}
+	if header == nil || header.Indices["orders"] == nil {
+		t.Fatal("dump has no header of orders")
+	}
+	if len(header.Indices["orders"].Aliases) > 0 || len(header.Indices["orders"].Settings) == 0 {
+		t.Errorf("header of orders is %+v, expected settings without aliases", header.Indices["orders"])
+	}
+
+	header, docs = dumpWithout(t, "_mapping")
+	if header != nil || len(docs) != 250 {
+		t.Errorf("dump has the header %+v and %d documents, expected 250 without header", header, len(docs))
+	}
+}
+
+func TestFileToCluster(t *testing.T) {
+	for _, sourceVersion := range versions {
+		source := newSource(t, sourceVersion)
+		file := filepath.Join(t.TempDir(), "dump.json")
+		run(t, newConfig(t, "-s", source.URL, "-o", file, "-x", "orders"))
+
+		for _, targetVersion := range versions {
+			t.Run(sourceVersion+"_to_"+targetVersion, func(t *testing.T) {
+				target := esmtest.NewServer(targetVersion)
+				defer target.Close()
+
+				report := run(t, newConfig(t, "-i", file, "-d", target.URL, "--copy_settings", "--copy_mappings", "--alias", "orders_live", "-w", "2"))
+
+				if report.Total.Written != 250 || report.Total.Failed != 0 {
+					t.Errorf("report counts %+v, expected 250 written", report.Total)
+				}
+				checkCount(t, target, "orders", 250)
+				checkNameMapping(t, target, "orders")
+				checkAlias(t, target, "orders_live", "orders")
+				checkAlias(t, target, "orders_read", "orders")
+			})
+		}
+	}
+}
+
+func TestClusterToClusterExclusion(t *testing.T) {
+	source := newSource(t, "7.10.2")
+	source.AddDocs("logs-old", 5)
+	target := esmtest.NewServer("8.15.0")
+	defer target.Close()
+
+	run(t, newConfig(t, "-s", source.URL, "-d", target.URL, "-x", "*,-logs*", "--copy_mappings", "--alias", "current"))
+
+	checkCount(t, target, "orders", 250)
+	if target.Index("logs") != nil || target.Index("logs-old") != nil {
+		t.Error("excluded indexes were copied")
+	}
+	checkAlias(t, target, "current", "orders")
+}
+
+func TestFileWithoutHeaderToCluster(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dump.json")
+	docs := bytes.Buffer{}
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&docs, `{"_index":"orders","_type":"doc","_id":"%d","_source":{"n":%d}}`+"\n", i, i)
+	}
+	if err := os.WriteFile(file, docs.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := esmtest.NewServer("7.10.2")
+	defer target.Close()
+
+	if _, err := migrate.Run(context.Background(), newConfig(t, "-i", file, "-d", target.URL, "--alias", "orders_live")); err == nil {
+		t.Error("alias of a dump without header was moved without --dest_index")
+	}
+
+	run(t, newConfig(t, "-i", file, "-d", target.URL, "-y", "orders", "--alias", "orders_live"))
+	checkCount(t, target, "orders", 20)
+	checkAlias(t, target, "orders_live", "orders")
+}
+
+func TestFileWithMalformedLineToCluster(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dump.json")
+	docs := bytes.Buffer{}
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&docs, `{"_index":"orders","_type":"doc","_id":"%d","_source":{"n":%d}}`+"\r\n", i, i)
+		if i == 10 {
+			docs.WriteString(`{"_index":"orders","_id":` + "\n\n")
+		}
+	}
+	if err := os.WriteFile(file, docs.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := esmtest.NewServer("7.10.2")
+	defer target.Close()
+
+	report := run(t, newConfig(t, "-i", file, "-d", target.URL, "-y", "orders", "--readers", "3"))
+	if report.Status != "partial" || report.ExitCode != migrate.ExitPartial || report.Total.Skipped != 1 || report.Total.Written != 20 {
+		t.Errorf("report %s, exit code %d, counts %+v, expected a partial migration of a skipped line",
+			report.Status, report.ExitCode, report.Total)
+	}
+	checkCount(t, target, "orders", 20)
+}
+
+// aliasTarget returns a target of the version where the alias orders points
+// to the old index orders_v1
+func aliasTarget(t *testing.T, version string) *esmtest.Server {
+	t.Helper()
+	target := esmtest.NewServer(version)
+	t.Cleanup(target.Close)
+	target.AddDocs("orders_v1", 10)
+	target.Index("orders_v1").Aliases["orders"] = map[string]interface{}{}
+	return target
+}
+
+func TestAliasDeleteOldIndex(t *testing.T) {
+	source := newSource(t, "7.10.2")
+	args := []string{"-x", "orders", "-y", "orders_v2", "--alias", "orders", "--old_index_action", "delete"}
+
+	// the tests have no terminal to confirm on
+	target := aliasTarget(t, "7.10.2")
+	if _, err := migrate.Run(context.Background(), newConfig(t, append([]string{"-s", source.URL, "-d", target.URL}, args...)...)); err == nil || !strings.Contains(err.Error(), "confirmation") {
+		t.Errorf("delete of the old index without confirmation returned %v", err)
+	}
+	checkAlias(t, target, "orders", "orders_v1")
+	checkCount(t, target, "orders_v1", 10)
+
+	target = aliasTarget(t, "7.10.2")
+	target.Fail("/orders_v1", 0, 500)
+	report, err := migrate.Run(context.Background(), newConfig(t, append([]string{"-s", source.URL, "-d", target.URL, "--yes"}, args...)...))
+	if err == nil || report.Status != "failed" || !strings.Contains(report.Error, "delete orders_v1") {
+		t.Errorf("failed delete of the old index returned %v and a report %s: %s", err, report.Status, report.Error)
+	}
+	checkAlias(t, target, "orders", "orders_v2")
+
+	target = aliasTarget(t, "7.10.2")
+	run(t, newConfig(t, append([]string{"-s", source.URL, "-d", target.URL, "--yes"}, args...)...))
+	checkAlias(t, target, "orders", "orders_v2")
+	if target.Index("orders_v1") != nil {
+		t.Error("old index orders_v1 was not deleted")
+	}
+}
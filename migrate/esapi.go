@@ -0,0 +1,40 @@
+package migrate
+
+import (
+	"bytes"
+	"time"
+)
+
+type ESAPI interface {
+	ClusterHealth() *ClusterHealth
+	WaitForStatus(indexNames string, status string, timeout time.Duration) error
+	Bulk(data *bytes.Buffer) (*BulkResponse, error)
+	GetIndexSettings(indexNames string) (*Indexes, error)
+	DeleteIndex(name string) error
+	Snapshot(repository string, snapshot string, indexNames string) error
+	CreateRepository(name string, settings map[string]interface{}) error
+	StartSnapshot(repository string, snapshot string, indexNames string) error
+	SnapshotStatus(repository string, snapshot string) (*SnapshotStatus, error)
+	RestoreSnapshot(repository string, snapshot string, indexNames string, renamePattern string, renameReplacement string) error
+	Recovery(indexNames string) (*RecoveryStatus, error)
+	Reindex(source string, dest string) error
+	StartReindex(request map[string]interface{}) (string, error)
+	TaskStatus(taskId string) (*TaskStatus, error)
+	CancelTask(taskId string) error
+	CloseIndex(name string) error
+	GetAliases(alias string) ([]string, error)
+	GetIndexAliases(indexNames string) (AliasesResponse, error)
+	UpdateAliases(actions []map[string]interface{}) error
+	CreateIndex(name string, settings map[string]interface{}) error
+	GetIndexMappings(copyAllIndexes bool, indexNames string) (string, int, *Indexes, error)
+	UpdateIndexSettings(indexName string, settings map[string]interface{}) error
+	UpdateIndexMapping(indexName string, mappings map[string]interface{}) error
+	NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string, preference string) (interface{}, error)
+	NextScroll(scrollTime string, scrollId string) (interface{}, error)
+	ClearScroll(scrollId string) error
+	Refresh(name string) (err error)
+	GetThreadPoolQueue() (int, error)
+	GetIndexStats(indexNames string) (map[string]*IndexStats, error)
+	Count(indexNames string, query string) (int, error)
+	FieldStats(indexNames string, field string, query string) (*FieldStats, error)
+}
@@ -0,0 +1,319 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+	"github.com/mattn/go-isatty"
+	"golang.org/x/sync/errgroup"
+)
+
+func checkFileIsExist(filename string) bool {
+	var exist = true
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		exist = false
+	}
+	return exist
+}
+
+// NewFileReadWorker puts the documents of the dump file, or the rows of a
+// csv or parquet file, into the queue and closes it when the file is read or
+// ctx is cancelled. A local dump file is split at lines into --readers parts
+// read in parallel, the order of the documents is not kept then. A bar in
+// bytes counts the bytes read, else the documents.
+func (m *Migrator) NewFileReadWorker(ctx context.Context, bar *pb.ProgressBar) error {
+	defer m.Docs.Close()
+
+	if m.Config.InputFileType == "csv" || m.Config.InputFileType == "parquet" {
+		return m.importRows(ctx, bar)
+	}
+
+	log.Debug("start reading file")
+	f, err := m.openDumpInput(ctx)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// only regular files can be split
+	file, local := f.(*os.File)
+	if local && m.Config.Readers > 1 {
+		info, err := file.Stat()
+		local = err == nil && info.Mode().IsRegular()
+	}
+	if !local || m.Config.Readers <= 1 {
+		if err := m.readLines(ctx, f, bar); err != nil {
+			return err
+		}
+		log.Debug("end reading file")
+		return nil
+	}
+
+	ranges, err := lineRanges(file, m.Config.Readers)
+	if err != nil {
+		return err
+	}
+	log.Debugf("reading %d parts of %s in parallel", len(ranges), m.Config.DumpInputFile)
+	readers, ctx := errgroup.WithContext(ctx)
+	for _, r := range ranges {
+		part := io.NewSectionReader(file, r[0], r[1]-r[0])
+		readers.Go(func() error {
+			return m.readLines(ctx, part, bar)
+		})
+	}
+	if err := readers.Wait(); err != nil {
+		return err
+	}
+	log.Debug("end reading file")
+	return nil
+}
+
+// readLines puts the documents of the lines into the queue
+func (m *Migrator) readLines(ctx context.Context, f io.Reader, bar *pb.ProgressBar) error {
+	r := bufio.NewReader(f)
+	for ctx.Err() == nil {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// the last line may have no newline
+		if len(line) == 0 {
+			return nil
+		}
+		if bar.Units == pb.U_BYTES {
+			bar.Add(len(line))
+		}
+		if isDumpHeader([]byte(line)) || len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		// a malformed line is a skipped document of the file
+		hit := &Hit{}
+		if err := json.Unmarshal([]byte(line), hit); err != nil {
+			m.reportError(fmt.Errorf("invalid line of %s: %v", m.Config.DumpInputFile, err))
+			m.Metrics.AddSkipped(m.Config.DumpInputFile, 1)
+			continue
+		}
+		m.Metrics.AddScrolled(hit.Index, 1)
+		m.Docs.Put(hit)
+		if bar.Units != pb.U_BYTES {
+			bar.Increment()
+		}
+	}
+	return nil
+}
+
+// lineRanges splits the file into up to n ranges of about the same size,
+// each range but the first starts after a newline
+func lineRanges(f *os.File, n int) ([][2]int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	ranges := [][2]int64{}
+	start := int64(0)
+	for i := 1; i <= n && start < size; i++ {
+		end := size
+		if i < n {
+			end, err = nextLine(f, size*int64(i)/int64(n), size)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if end > start {
+			ranges = append(ranges, [2]int64{start, end})
+			start = end
+		}
+	}
+	return ranges, nil
+}
+
+// nextLine returns the offset of the line after the one at offset
+func nextLine(f *os.File, offset int64, size int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	// a line ending right before offset ends the previous range
+	r := bufio.NewReader(io.NewSectionReader(f, offset-1, size-offset+1))
+	line, err := r.ReadString('\n')
+	if err == io.EOF {
+		return size, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return offset - 1 + int64(len(line)), nil
+}
+
+// countLines returns the number of lines of the file
+func countLines(name string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	lines := 0
+	buf := make([]byte, 1024*1024)
+	for {
+		n, err := f.Read(buf)
+		lines += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// stdio is the input or output file name of stdin and stdout
+const stdio = "-"
+
+// Console returns the file of logs, progress bars and the report, stderr
+// when the documents are dumped to stdout
+func (c *Config) Console() *os.File {
+	if c.DumpOutFile == stdio {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// IsTerminal tells if progress bars can be drawn on the file
+func IsTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// openDumpInput opens the input file, stdin for -, or the objects of an s3
+// input
+func (m *Migrator) openDumpInput(ctx context.Context) (io.ReadCloser, error) {
+	if m.Config.DumpInputFile == stdio {
+		// the rest of stdin after its header was read
+		if m.stdin != nil {
+			return io.NopCloser(m.stdin), nil
+		}
+		return os.Stdin, nil
+	}
+	if isS3Path(m.Config.DumpInputFile) {
+		return m.newS3Reader(ctx)
+	}
+	return os.Open(m.Config.DumpInputFile)
+}
+
+// createDumpOutput opens the output file, documents are appended to an
+// existing one, stdout for -, or uploads into objects of an s3 output.
+// appending tells if documents of an earlier dump are there.
+func (c *Migrator) createDumpOutput(ctx context.Context) (f io.WriteCloser, appending bool, err error) {
+	if c.Config.DumpOutFile == stdio {
+		return os.Stdout, false, nil
+	}
+	if isS3Path(c.Config.DumpOutFile) {
+		w, err := c.newS3Writer(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		return w, w.next > 0, nil
+	}
+	if checkFileIsExist(c.Config.DumpOutFile) {
+		info, err := os.Stat(c.Config.DumpOutFile)
+		if err != nil {
+			return nil, false, err
+		}
+		f, err := os.OpenFile(c.Config.DumpOutFile, os.O_APPEND|os.O_WRONLY, os.ModeAppend)
+		return f, info.Size() > 0, err
+	}
+	f, err = os.Create(c.Config.DumpOutFile)
+	return f, false, err
+}
+
+// NewFileDumpWorker writes the documents of the queue into the output file
+// until the queue is closed and drained, csv and parquet are written as rows.
+// Uploads to s3 are cancelled by ctx and aborted when the dump fails.
+func (c *Migrator) NewFileDumpWorker(ctx context.Context, pb *pb.ProgressBar) (err error) {
+	if c.Config.OutputFormat != "dump" {
+		return c.exportRows(pb)
+	}
+
+	f, appending, err := c.createDumpOutput(ctx)
+	if err != nil {
+		return err
+	}
+	// the output is closed once only, by the end of the dump or here
+	finished := false
+	defer func() {
+		if !finished {
+			abortDumpOutput(f, err)
+		}
+	}()
+
+	w := bufio.NewWriter(f)
+
+	// the header of an earlier dump is kept
+	if !appending && c.SourceESAPI != nil {
+		if err := c.writeDumpHeader(w); err != nil {
+			return err
+		}
+	}
+
+	for hit := range c.Docs.C() {
+		// sanity check
+		if len(hit.Index) == 0 || len(hit.Source) == 0 {
+			log.Errorf("invalid document without _index or _source, index: %q, id: %q", hit.Index, hit.Id)
+			c.Metrics.AddSkipped(hit.Index, 1)
+			c.Docs.Done(hit)
+			hit.ack(true)
+			continue
+		}
+		if !c.transform(hit) {
+			c.Metrics.AddSkipped(hit.Index, 1)
+			c.Docs.Done(hit)
+			hit.ack(true)
+			continue
+		}
+
+		// the _source is compacted into one line
+		jsr, err := json.Marshal(hit)
+		c.Docs.Done(hit)
+		log.Trace(string(jsr))
+		if err != nil {
+			log.Error(err)
+			c.Metrics.AddSkipped(hit.Index, 1)
+			hit.ack(true)
+			continue
+		}
+		jsr = append(jsr, '\n')
+		if _, err := w.Write(jsr); err != nil {
+			hit.ack(false)
+			return err
+		}
+		c.Metrics.AddBulked(hit.Index, 1)
+		hit.ack(true)
+		pb.Increment()
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	log.Debug("file dump finished")
+	finished = true
+	return f.Close()
+}
+
+// abortDumpOutput closes the output of a failed dump, an upload to s3 is
+// aborted instead of completed
+func abortDumpOutput(f io.WriteCloser, err error) {
+	if w, ok := f.(*s3Writer); ok {
+		w.Abort(err)
+		return
+	}
+	f.Close()
+}
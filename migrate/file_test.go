@@ -0,0 +1,77 @@
+package migrate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLineRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		n        int
+		expected [][2]int64
+	}{
+		{"line straddling the split", "aaaa\nbbbbbbbb\ncc\n", 2, [][2]int64{{0, 14}, {14, 17}}},
+		{"split at a line start", "aaa\nbbb\n", 2, [][2]int64{{0, 4}, {4, 8}}},
+		{"crlf", "aa\r\nbb\r\n", 2, [][2]int64{{0, 4}, {4, 8}}},
+		{"split between cr and lf", "a\r\nb\r\n", 2, [][2]int64{{0, 3}, {3, 6}}},
+		{"no trailing newline", "aaa\nbbb", 2, [][2]int64{{0, 4}, {4, 7}}},
+		{"last line straddling without newline", "aaa\nbbbbbb", 2, [][2]int64{{0, 10}}},
+		{"more parts than lines", "a\nb\n", 8, [][2]int64{{0, 2}, {2, 4}}},
+		{"one part", "aaa\nbbb\n", 1, [][2]int64{{0, 8}}},
+		{"empty", "", 4, [][2]int64{}},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "dump.json")
+		if err := os.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ranges, err := lineRanges(f, test.n)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(ranges, test.expected) {
+			t.Errorf("%s: ranges %v, expected %v", test.name, ranges, test.expected)
+		}
+		// the parts are whole lines of the file
+		for i, r := range ranges {
+			part := test.content[r[0]:r[1]]
+			if i < len(ranges)-1 && !strings.HasSuffix(part, "\n") {
+				t.Errorf("%s: part %q doesn't end a line", test.name, part)
+			}
+		}
+	}
+}
+
+func TestNextLine(t *testing.T) {
+	content := "aaa\r\nbbb\nccc"
+	path := filepath.Join(t.TempDir(), "dump.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	size := int64(len(content))
+	for offset, expected := range map[int64]int64{0: 0, 1: 5, 4: 5, 5: 5, 6: 9, 9: 9, 10: size, 12: size} {
+		next, err := nextLine(f, offset, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != expected {
+			t.Errorf("line after offset %d starts at %d, expected %d", offset, next, expected)
+		}
+	}
+}
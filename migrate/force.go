@@ -0,0 +1,106 @@
+package migrate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/mattn/go-isatty"
+)
+
+// jobs running in parallel ask one at a time
+var confirmLock sync.Mutex
+
+// protectedPattern returns the pattern of --protected_indices matching the index
+func protectedPattern(patterns string, name string) (string, bool) {
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// confirm asks a yes/no question on the terminal
+func confirm(question string) (bool, error) {
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return false, errors.New("stdin is not a terminal, use --yes to confirm")
+	}
+
+	confirmLock.Lock()
+	defer confirmLock.Unlock()
+	log.Flush()
+
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// deleteTargetIndices deletes the existing target indexes for --force,
+// protected indexes abort the migration, the deletion has to be confirmed
+// unless --yes is set and the indexes are backed up first if asked
+func (m *Migrator) deleteTargetIndices(names []string) error {
+	c := m.Config
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if pattern, ok := protectedPattern(c.ProtectedIndices, name); ok {
+			return fmt.Errorf("refusing to delete index %s on target, it is protected by pattern %s", name, pattern)
+		}
+	}
+
+	if !c.AssumeYes && !c.DryRun {
+		question := fmt.Sprintf("delete %d index(es) on %s: %s?", len(names), c.TargetEs, strings.Join(names, ", "))
+		ok, err := confirm(question)
+		if err != nil {
+			return fmt.Errorf("--force needs confirmation: %v", err)
+		}
+		if !ok {
+			return errors.New("deleting target indexes was not confirmed")
+		}
+	}
+
+	suffix := time.Now().Format("20060102150405")
+	switch c.Backup {
+	case "snapshot":
+		snapshot := "esm-backup-" + suffix
+		log.Infof("snapshot %s into %s/%s", strings.Join(names, ","), c.SnapshotRepository, snapshot)
+		if err := m.TargetESAPI.Snapshot(c.SnapshotRepository, snapshot, strings.Join(names, ",")); err != nil {
+			return err
+		}
+	case "rename":
+		for _, name := range names {
+			backup := name + "-esm-backup-" + suffix
+			log.Infof("backup index %s into %s", name, backup)
+			if err := m.TargetESAPI.Reindex(name, backup); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range names {
+		log.Infof("delete index %s on target", name)
+		if err := m.TargetESAPI.DeleteIndex(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,773 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// column of a csv or parquet export, a field of the mapping. The kind is
+// long, double, boolean, keyword or json, json columns hold arrays and
+// objects that can't be flattened, as json.
+type column struct {
+	name string
+	kind string
+}
+
+// the metadata of the documents come first
+var metaColumns = []column{{"_index", "keyword"}, {"_type", "keyword"}, {"_id", "keyword"}, {"_routing", "keyword"}}
+
+// columnKinds are the kinds of the elasticsearch field types, other types
+// are json
+var columnKinds = map[string]string{
+	"long":             "long",
+	"integer":          "long",
+	"short":            "long",
+	"byte":             "long",
+	"double":           "double",
+	"float":            "double",
+	"half_float":       "double",
+	"scaled_float":     "double",
+	"boolean":          "boolean",
+	"keyword":          "keyword",
+	"text":             "keyword",
+	"string":           "keyword",
+	"match_only_text":  "keyword",
+	"constant_keyword": "keyword",
+	"wildcard":         "keyword",
+	"date":             "keyword",
+	"date_nanos":       "keyword",
+	"ip":               "keyword",
+	"version":          "keyword",
+	"unsigned_long":    "keyword",
+}
+
+// mappingFields adds the leaf fields of the mapping of an index with their
+// types, objects are flattened to dotted names. The mappings of 1.x to 6.x
+// are nested by type.
+func mappingFields(mappings interface{}, fields map[string]string) {
+	m, _ := mappings.(map[string]interface{})
+	if properties, ok := m["properties"].(map[string]interface{}); ok {
+		propertyFields("", properties, fields)
+		return
+	}
+	for _, typeMapping := range m {
+		if t, ok := typeMapping.(map[string]interface{}); ok {
+			if properties, ok := t["properties"].(map[string]interface{}); ok {
+				propertyFields("", properties, fields)
+			}
+		}
+	}
+}
+
+func propertyFields(prefix string, properties map[string]interface{}, fields map[string]string) {
+	for name, property := range properties {
+		p, _ := property.(map[string]interface{})
+		fieldType, _ := p["type"].(string)
+		if sub, ok := p["properties"].(map[string]interface{}); ok && (fieldType == "" || fieldType == "object") {
+			propertyFields(prefix+name+".", sub, fields)
+			continue
+		}
+		fields[prefix+name] = fieldType
+	}
+}
+
+// indexFields returns the fields of every index of the mappings
+func indexFields(mappings *Indexes) map[string]map[string]string {
+	indices := map[string]map[string]string{}
+	for name, index := range *mappings {
+		fields := map[string]string{}
+		if idx, ok := index.(map[string]interface{}); ok {
+			mappingFields(idx["mappings"], fields)
+		}
+		indices[name] = fields
+	}
+	return indices
+}
+
+func columnKind(fieldType string) string {
+	if kind, ok := columnKinds[fieldType]; ok {
+		return kind
+	}
+	return "json"
+}
+
+// exportColumns returns the columns of the fields of all indexes, sorted by
+// name. A field of different kinds in several indexes is a keyword column,
+// or a double column for long and double.
+func exportColumns(mappings *Indexes) []column {
+	kinds := map[string]string{}
+	for _, fields := range indexFields(mappings) {
+		for name, fieldType := range fields {
+			kind := columnKind(fieldType)
+			if previous, ok := kinds[name]; ok && previous != kind {
+				if (previous == "long" || previous == "double") && (kind == "long" || kind == "double") {
+					kind = "double"
+				} else {
+					kind = "keyword"
+				}
+			}
+			kinds[name] = kind
+		}
+	}
+
+	names := []string{}
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	columns := append([]column{}, metaColumns...)
+	for _, name := range names {
+		columns = append(columns, column{name, kinds[name]})
+	}
+	return columns
+}
+
+// flattenSource puts the fields of the _source into the row by column name,
+// objects are flattened unless they are the value of a column
+func flattenSource(prefix string, source map[string]interface{}, columns map[string]column, row map[string]interface{}) {
+	for name, value := range source {
+		if object, ok := value.(map[string]interface{}); ok {
+			if _, ok := columns[prefix+name]; !ok {
+				flattenSource(prefix+name+".", object, columns, row)
+				continue
+			}
+		}
+		row[prefix+name] = value
+	}
+}
+
+// cell formats the value for csv, arrays and objects are written as json
+func cell(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+// typed converts the value to the go type of the parquet column, false if
+// it doesn't fit, like an array of numbers in a long column
+func (c column) typed(value interface{}) (interface{}, bool) {
+	switch c.kind {
+	case "long":
+		switch v := value.(type) {
+		case json.Number:
+			n, err := v.Int64()
+			return n, err == nil
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			return n, err == nil
+		}
+		return nil, false
+	case "double":
+		switch v := value.(type) {
+		case json.Number:
+			n, err := v.Float64()
+			return n, err == nil
+		case string:
+			n, err := strconv.ParseFloat(v, 64)
+			return n, err == nil
+		}
+		return nil, false
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(v)
+			return b, err == nil
+		}
+		return nil, false
+	}
+	return cell(value), true
+}
+
+// rowWriter writes the documents of an export as rows of the columns
+type rowWriter interface {
+	Write(row map[string]interface{}) error
+	Close() error
+}
+
+type csvRowWriter struct {
+	file    *os.File
+	w       *csv.Writer
+	columns []column
+}
+
+func newCSVRowWriter(path string, columns []column) (*csvRowWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(file)
+	header := []string{}
+	for _, c := range columns {
+		header = append(header, c.name)
+	}
+	if err := w.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &csvRowWriter{file: file, w: w, columns: columns}, nil
+}
+
+func (w *csvRowWriter) Write(row map[string]interface{}) error {
+	record := make([]string, len(w.columns))
+	for i, c := range w.columns {
+		record[i] = cell(row[c.name])
+	}
+	return w.w.Write(record)
+}
+
+func (w *csvRowWriter) Close() error {
+	w.w.Flush()
+	if err := w.w.Error(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+type parquetRowWriter struct {
+	file    source.ParquetFile
+	w       *writer.JSONWriter
+	columns []column
+}
+
+// columnMismatchError is the error of a row with a value that doesn't fit
+// the type of its parquet column, like an array in a long column. The row is
+// not written, the columns of a parquet file can't be widened once rows are
+// written.
+type columnMismatchError struct {
+	column column
+	value  interface{}
+}
+
+func (e *columnMismatchError) Error() string {
+	return fmt.Sprintf("value %s of %s doesn't fit its %s column", cell(e.value), e.column.name, e.column.kind)
+}
+
+func newParquetRowWriter(path string, columns []column) (*parquetRowWriter, error) {
+	fields := []map[string]string{}
+	for _, c := range columns {
+		tag := "name=" + c.name + ", repetitiontype=OPTIONAL"
+		switch c.kind {
+		case "long":
+			tag += ", type=INT64"
+		case "double":
+			tag += ", type=DOUBLE"
+		case "boolean":
+			tag += ", type=BOOLEAN"
+		default:
+			tag += ", type=BYTE_ARRAY, convertedtype=UTF8"
+		}
+		fields = append(fields, map[string]string{"Tag": tag})
+	}
+	schema, err := json.Marshal(map[string]interface{}{"Tag": "name=esm", "Fields": fields})
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := writer.NewJSONWriter(string(schema), file, 1)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetRowWriter{file: file, w: w, columns: columns}, nil
+}
+
+func (w *parquetRowWriter) Write(row map[string]interface{}) error {
+	values := map[string]interface{}{}
+	for _, c := range w.columns {
+		value, present := row[c.name]
+		if !present || value == nil {
+			continue
+		}
+		typed, ok := c.typed(value)
+		if !ok {
+			return &columnMismatchError{column: c, value: value}
+		}
+		values[c.name] = typed
+	}
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return w.w.Write(string(b))
+}
+
+func (w *parquetRowWriter) Close() error {
+	if err := w.w.WriteStop(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// exportRows writes the documents of the queue into the output file as csv
+// or parquet rows, with a column per field of the source mapping
+func (c *Migrator) exportRows(pb *pb.ProgressBar) error {
+	_, _, mappings, err := c.SourceESAPI.GetIndexMappings(c.Config.CopyAllIndexes, c.Config.SourceIndexNames)
+	if err != nil {
+		return err
+	}
+	columns := exportColumns(mappings)
+	columnsByName := map[string]column{}
+	for _, col := range columns {
+		columnsByName[col.name] = col
+	}
+
+	var w rowWriter
+	if c.Config.OutputFormat == "parquet" {
+		w, err = newParquetRowWriter(c.Config.DumpOutFile, columns)
+	} else {
+		w, err = newCSVRowWriter(c.Config.DumpOutFile, columns)
+	}
+	if err != nil {
+		return err
+	}
+	log.Debugf("exporting %d columns as %s", len(columns), c.Config.OutputFormat)
+
+	dropped := map[string]bool{}
+	for hit := range c.Docs.C() {
+		if len(hit.Index) == 0 || len(hit.Source) == 0 {
+			log.Errorf("invalid document without _index or _source, index: %q, id: %q", hit.Index, hit.Id)
+			c.Metrics.AddSkipped(hit.Index, 1)
+			c.Docs.Done(hit)
+			hit.ack(true)
+			continue
+		}
+		if !c.transform(hit) {
+			c.Metrics.AddSkipped(hit.Index, 1)
+			c.Docs.Done(hit)
+			hit.ack(true)
+			continue
+		}
+
+		source := map[string]interface{}{}
+		decoder := json.NewDecoder(bytes.NewReader(hit.Source))
+		decoder.UseNumber()
+		err := decoder.Decode(&source)
+		c.Docs.Done(hit)
+		if err != nil {
+			c.reportError(fmt.Errorf("invalid _source of document [%s/%s]: %v", hit.Index, hit.Id, err))
+			c.Metrics.AddSkipped(hit.Index, 1)
+			hit.ack(true)
+			continue
+		}
+
+		row := map[string]interface{}{}
+		flattenSource("", source, columnsByName, row)
+		for name := range row {
+			if _, ok := columnsByName[name]; !ok && !dropped[name] {
+				dropped[name] = true
+				log.Warnf("field %s of index %s is not in the mapping, it is not exported", name, hit.Index)
+			}
+		}
+		row["_index"] = hit.Index
+		row["_type"] = hit.Type
+		row["_id"] = hit.Id
+		row["_routing"] = hit.Routing
+
+		if err := w.Write(row); err != nil {
+			var mismatch *columnMismatchError
+			if errors.As(err, &mismatch) {
+				c.reportError(fmt.Errorf("document [%s/%s] is not exported: %v", hit.Index, hit.Id, err))
+				c.Metrics.AddSkipped(hit.Index, 1)
+				hit.ack(true)
+				continue
+			}
+			hit.ack(false)
+			w.Close()
+			return err
+		}
+		c.Metrics.AddBulked(hit.Index, 1)
+		hit.ack(true)
+		pb.Increment()
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+	log.Debugf("%s export finished", c.Config.OutputFormat)
+	return nil
+}
+
+// rowReader reads the rows of a csv or parquet file, io.EOF after the last
+type rowReader interface {
+	Read() (map[string]interface{}, error)
+	Close() error
+}
+
+type csvRowReader struct {
+	file   *os.File
+	r      *csv.Reader
+	header []string
+}
+
+func newCSVRowReader(path string) (*csvRowReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(file)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &csvRowReader{file: file, r: r, header: append([]string{}, header...)}, nil
+}
+
+// Read returns the cells of the row, empty cells are empty text
+func (r *csvRowReader) Read() (map[string]interface{}, error) {
+	record, err := r.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := map[string]interface{}{}
+	for i, value := range record {
+		if i < len(r.header) {
+			row[r.header[i]] = value
+		}
+	}
+	return row, nil
+}
+
+func (r *csvRowReader) Close() error {
+	return r.file.Close()
+}
+
+type parquetRowReader struct {
+	file  source.ParquetFile
+	r     *reader.ParquetReader
+	names []string
+	rows  []map[string]interface{}
+	left  int64
+}
+
+// newParquetRowReader opens the parquet file, nested columns are not
+// supported
+func newParquetRowReader(path string) (*parquetRowReader, error) {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := reader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	schema := r.SchemaHandler
+	if int(schema.SchemaElements[0].GetNumChildren()) != len(schema.ValueColumns) {
+		r.ReadStop()
+		file.Close()
+		return nil, fmt.Errorf("%s has nested columns, only flat parquet files are supported", path)
+	}
+	names := []string{}
+	for _, column := range schema.ValueColumns {
+		names = append(names, schema.Infos[schema.MapIndex[column]].ExName)
+	}
+	return &parquetRowReader{file: file, r: r, names: names, left: r.GetNumRows()}, nil
+}
+
+// Read returns the typed values of the row, nulls are missing fields
+func (r *parquetRowReader) Read() (map[string]interface{}, error) {
+	if len(r.rows) == 0 {
+		if r.left == 0 {
+			return nil, io.EOF
+		}
+		count := r.left
+		if count > 1000 {
+			count = 1000
+		}
+		r.left -= count
+		r.rows = make([]map[string]interface{}, count)
+		for i := range r.rows {
+			r.rows[i] = map[string]interface{}{}
+		}
+		// the values of the rows are read column by column
+		for column, name := range r.names {
+			values, _, _, err := r.r.ReadColumnByIndex(int64(column), count)
+			if err != nil {
+				return nil, err
+			}
+			for i, value := range values {
+				if i >= len(r.rows) || value == nil {
+					continue
+				}
+				switch v := value.(type) {
+				case int32:
+					r.rows[i][name] = int64(v)
+				case float32:
+					r.rows[i][name] = float64(v)
+				default:
+					r.rows[i][name] = v
+				}
+			}
+		}
+	}
+	row := r.rows[0]
+	r.rows = r.rows[1:]
+	return row, nil
+}
+
+func (r *parquetRowReader) Close() error {
+	r.r.ReadStop()
+	return r.file.Close()
+}
+
+// parquetRowCount returns the number of rows of the parquet file
+func parquetRowCount(path string) (int, error) {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	r, err := reader.NewParquetColumnReader(file, 1)
+	if err != nil {
+		return 0, err
+	}
+	defer r.ReadStop()
+	return int(r.GetNumRows()), nil
+}
+
+// coerce converts the text of a field to the type of the field in the
+// mapping of target, the type is guessed without mapping. Arrays and objects
+// were exported as json, they are parsed for json columns and for arrays of
+// numbers and booleans, keyword text is kept as is. nil is returned for an
+// empty text that is no value of the column, the field is missing then.
+func coerce(fieldType string, text string) interface{} {
+	kind := columnKind(fieldType)
+	if len(fieldType) == 0 {
+		// no mapping, numbers and booleans are guessed
+		kind = "guess"
+	}
+	switch kind {
+	case "keyword":
+		return text
+	case "json":
+		if value, ok := parseJSON(text); ok {
+			return value
+		}
+		if len(text) == 0 {
+			return nil
+		}
+		return text
+	}
+	if len(text) == 0 {
+		if kind == "guess" {
+			return text
+		}
+		return nil
+	}
+
+	if kind == "long" || kind == "guess" {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return n
+		}
+	}
+	if kind == "double" || kind == "guess" {
+		if n, err := strconv.ParseFloat(text, 64); err == nil {
+			return n
+		}
+	}
+	if kind == "boolean" || kind == "guess" {
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b
+		}
+	}
+	if kind != "guess" && strings.HasPrefix(text, "[") {
+		if value, ok := parseJSON(text); ok {
+			return value
+		}
+	}
+	return text
+}
+
+// parseJSON parses the text of an exported array or object, numbers are kept
+// as they are
+func parseJSON(text string) (interface{}, bool) {
+	if !strings.HasPrefix(text, "[") && !strings.HasPrefix(text, "{") {
+		return nil, false
+	}
+	decoder := json.NewDecoder(strings.NewReader(text))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil || decoder.More() {
+		return nil, false
+	}
+	return value, true
+}
+
+// unflatten turns the dotted fields of a row back into objects, a field
+// that is also an object keeps its dotted name
+func unflatten(row map[string]interface{}) map[string]interface{} {
+	names := []string{}
+	for name := range row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	source := map[string]interface{}{}
+	for _, name := range names {
+		parts := strings.Split(name, ".")
+		object := source
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := object[part].(map[string]interface{})
+			if !ok {
+				if _, exists := object[part]; exists {
+					object = nil
+					break
+				}
+				child = map[string]interface{}{}
+				object[part] = child
+			}
+			object = child
+		}
+		if object == nil {
+			source[name] = row[name]
+			continue
+		}
+		object[parts[len(parts)-1]] = row[name]
+	}
+	return source
+}
+
+// importRows puts the rows of the csv or parquet input file into the queue,
+// text values are converted to the types of the mapping of the target index.
+// A bar in bytes counts the bytes of the csv file read, else the rows.
+func (m *Migrator) importRows(ctx context.Context, bar *pb.ProgressBar) error {
+	var r rowReader
+	var err error
+	if m.Config.InputFileType == "parquet" {
+		r, err = newParquetRowReader(m.Config.DumpInputFile)
+	} else {
+		r, err = newCSVRowReader(m.Config.DumpInputFile)
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// fields of the target indexes by name, empty without mapping
+	targetFields := map[string]map[string]string{}
+	fieldsOf := func(index string) map[string]string {
+		if fields, ok := targetFields[index]; ok {
+			return fields
+		}
+		fields := map[string]string{}
+		if m.TargetESAPI != nil {
+			if _, _, mappings, err := m.TargetESAPI.GetIndexMappings(false, index); err == nil && mappings != nil {
+				for _, f := range indexFields(mappings) {
+					for name, fieldType := range f {
+						fields[name] = fieldType
+					}
+				}
+			}
+		}
+		log.Debugf("%d fields of index %s in the mapping of target", len(fields), index)
+		targetFields[index] = fields
+		return fields
+	}
+
+	rowCount := 0
+	for ctx.Err() == nil {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		rowCount++
+		if err != nil {
+			return fmt.Errorf("invalid row %d of %s: %v", rowCount, m.Config.DumpInputFile, err)
+		}
+
+		hit := &Hit{}
+		for _, meta := range metaColumns {
+			value, ok := row[meta.name]
+			if !ok {
+				continue
+			}
+			delete(row, meta.name)
+			switch meta.name {
+			case "_index":
+				hit.Index = cell(value)
+			case "_type":
+				hit.Type = cell(value)
+			case "_id":
+				hit.Id = cell(value)
+			case "_routing":
+				hit.Routing = cell(value)
+			}
+		}
+		index := hit.Index
+		if len(m.Config.TargetIndexName) > 0 {
+			index = m.Config.TargetIndexName
+		}
+
+		fields := fieldsOf(index)
+		for name, value := range row {
+			text, ok := value.(string)
+			if !ok {
+				continue
+			}
+			fieldType := fields[name]
+			if len(fieldType) == 0 && m.Config.InputFileType == "parquet" {
+				// parquet columns are typed, text stays text
+				fieldType = "keyword"
+			}
+			value := coerce(fieldType, text)
+			if value == nil {
+				delete(row, name)
+				continue
+			}
+			row[name] = value
+		}
+		hit.Source, err = json.Marshal(unflatten(row))
+		if err != nil {
+			m.reportError(fmt.Errorf("invalid row %d of %s: %v", rowCount, m.Config.DumpInputFile, err))
+			m.Metrics.AddSkipped(hit.Index, 1)
+			continue
+		}
+		m.Metrics.AddScrolled(hit.Index, 1)
+		m.Docs.Put(hit)
+		if csvReader, ok := r.(*csvRowReader); ok && bar.Units == pb.U_BYTES {
+			bar.Set64(csvReader.r.InputOffset())
+		} else {
+			bar.Increment()
+		}
+	}
+
+	log.Debugf("end reading %s", m.Config.DumpInputFile)
+	return nil
+}
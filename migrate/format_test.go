@@ -0,0 +1,269 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// decode decodes the json with numbers as json.Number, like an export
+func decode(t *testing.T, text string) map[string]interface{} {
+	t.Helper()
+	value, ok := parseJSON(text)
+	if !ok {
+		t.Fatalf("invalid json %s", text)
+	}
+	return value.(map[string]interface{})
+}
+
+// normalized returns the value as plain json values, numbers are float64
+func normalized(t *testing.T, value interface{}) interface{} {
+	t.Helper()
+	b, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// testMappings has the fields of every column kind, logs is mapped by type
+// like 6.x
+var testMappings = Indexes{
+	"orders": map[string]interface{}{"mappings": map[string]interface{}{"properties": map[string]interface{}{
+		"n":        map[string]interface{}{"type": "long"},
+		"price":    map[string]interface{}{"type": "double"},
+		"paid":     map[string]interface{}{"type": "boolean"},
+		"name":     map[string]interface{}{"type": "text"},
+		"location": map[string]interface{}{"type": "geo_point"},
+		"user": map[string]interface{}{"properties": map[string]interface{}{
+			"id":   map[string]interface{}{"type": "keyword"},
+			"tags": map[string]interface{}{"type": "keyword"},
+		}},
+	}}},
+	"logs": map[string]interface{}{"mappings": map[string]interface{}{"doc": map[string]interface{}{"properties": map[string]interface{}{
+		"n":     map[string]interface{}{"type": "double"},
+		"paid":  map[string]interface{}{"type": "keyword"},
+		"level": map[string]interface{}{"type": "keyword"},
+	}}}},
+}
+
+func TestExportColumns(t *testing.T) {
+	expected := append(append([]column{}, metaColumns...),
+		column{"level", "keyword"},
+		column{"location", "json"},
+		column{"n", "double"},
+		column{"name", "keyword"},
+		column{"paid", "keyword"},
+		column{"price", "double"},
+		column{"user.id", "keyword"},
+		column{"user.tags", "keyword"},
+	)
+	if columns := exportColumns(&testMappings); !reflect.DeepEqual(columns, expected) {
+		t.Errorf("columns %v, expected %v", columns, expected)
+	}
+}
+
+func TestFlattenSource(t *testing.T) {
+	columns := map[string]column{"location": {"location", "json"}}
+	tests := []struct {
+		source   string
+		expected map[string]interface{}
+	}{
+		{`{"n":1,"user":{"id":"u1","tags":["a","b"]}}`, map[string]interface{}{"n": 1.0, "user.id": "u1", "user.tags": []interface{}{"a", "b"}}},
+		{`{"a":{"b":{"c":true}}}`, map[string]interface{}{"a.b.c": true}},
+		{`{"location":{"lat":1.5,"lon":2}}`, map[string]interface{}{"location": map[string]interface{}{"lat": 1.5, "lon": 2.0}}},
+		{`{"user":{}}`, map[string]interface{}{}},
+		{`{"name":null}`, map[string]interface{}{"name": nil}},
+	}
+	for _, test := range tests {
+		row := map[string]interface{}{}
+		flattenSource("", decode(t, test.source), columns, row)
+		if flat := normalized(t, row); !reflect.DeepEqual(flat, test.expected) {
+			t.Errorf("%s flattened to %v, expected %v", test.source, flat, test.expected)
+		}
+	}
+}
+
+func TestUnflatten(t *testing.T) {
+	tests := []struct {
+		row      map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{"n": 1, "user.id": "u1", "user.tags": []interface{}{"a"}}, `{"n":1,"user":{"id":"u1","tags":["a"]}}`},
+		{map[string]interface{}{"a.b.c": true, "a.d": "x"}, `{"a":{"b":{"c":true},"d":"x"}}`},
+		// a field that is also an object keeps its dotted name
+		{map[string]interface{}{"a": "x", "a.b": "y"}, `{"a":"x","a.b":"y"}`},
+		{map[string]interface{}{}, `{}`},
+	}
+	for _, test := range tests {
+		source, err := json.Marshal(unflatten(test.row))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(source) != test.expected {
+			t.Errorf("%v unflattened to %s, expected %s", test.row, source, test.expected)
+		}
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		fieldType string
+		text      string
+		expected  interface{}
+	}{
+		{"long", "42", int64(42)},
+		{"long", "4.2", "4.2"},
+		{"long", "[1,2]", []interface{}{json.Number("1"), json.Number("2")}},
+		{"long", "", nil},
+		{"integer", "9007199254740993", int64(9007199254740993)},
+		{"double", "4.2", 4.2},
+		{"double", "[1.5,2]", []interface{}{json.Number("1.5"), json.Number("2")}},
+		{"double", "", nil},
+		{"boolean", "true", true},
+		{"boolean", "[true,false]", []interface{}{true, false}},
+		{"boolean", "", nil},
+		// keyword text is never parsed
+		{"keyword", "42", "42"},
+		{"keyword", "[1,2]", "[1,2]"},
+		{"text", `{"a":1}`, `{"a":1}`},
+		{"keyword", "", ""},
+		// json columns hold arrays and objects
+		{"geo_point", `{"lat":1.5,"lon":2}`, map[string]interface{}{"lat": json.Number("1.5"), "lon": json.Number("2")}},
+		{"geo_point", "1.5,2", "1.5,2"},
+		{"geo_point", "[1.5,2] x", "[1.5,2] x"},
+		{"nested", "", nil},
+		// numbers and booleans are guessed without mapping
+		{"", "42", int64(42)},
+		{"", "4.2", 4.2},
+		{"", "false", false},
+		{"", "[1,2]", "[1,2]"},
+		{"", "abc", "abc"},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		if value := coerce(test.fieldType, test.text); !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("%q of a %q field coerced to %#v, expected %#v", test.text, test.fieldType, value, test.expected)
+		}
+	}
+}
+
+// roundTripSources are documents of orders, their fields fit the columns
+var roundTripSources = []string{
+	`{"n":1,"price":9.5,"paid":true,"name":"first","location":{"lat":1.5,"lon":2},"user":{"id":"u1","tags":"a"}}`,
+	`{"n":9007199254740993,"price":0,"paid":false,"name":"","location":{"lat":0,"lon":0},"user":{"id":"[u2]","tags":"{b}"}}`,
+}
+
+// writeRows exports the sources of orders as rows of the columns of the
+// mappings
+func writeRows(t *testing.T, w rowWriter, columns []column, sources []string) {
+	t.Helper()
+	columnsByName := map[string]column{}
+	for _, c := range columns {
+		columnsByName[c.name] = c
+	}
+	for i, source := range sources {
+		row := map[string]interface{}{}
+		flattenSource("", decode(t, source), columnsByName, row)
+		row["_index"] = "orders"
+		row["_id"] = string(rune('a' + i))
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readRows imports the rows like importRows, typed by the mapping of orders
+func readRows(t *testing.T, r rowReader, fieldTypes map[string]string) []interface{} {
+	t.Helper()
+	defer r.Close()
+	sources := []interface{}{}
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return sources
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, meta := range metaColumns {
+			delete(row, meta.name)
+		}
+		for name, value := range row {
+			if text, ok := value.(string); ok {
+				if value := coerce(fieldTypes[name], text); value != nil {
+					row[name] = value
+				} else {
+					delete(row, name)
+				}
+			}
+		}
+		sources = append(sources, normalized(t, unflatten(row)))
+	}
+}
+
+func TestRowsRoundTrip(t *testing.T) {
+	mappings := Indexes{"orders": testMappings["orders"]}
+	columns := exportColumns(&mappings)
+	fieldTypes := indexFields(&mappings)["orders"]
+	expected := []interface{}{}
+	for _, source := range roundTripSources {
+		expected = append(expected, normalized(t, decode(t, source)))
+	}
+
+	path := filepath.Join(t.TempDir(), "orders.csv")
+	csvWriter, err := newCSVRowWriter(path, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRows(t, csvWriter, columns, roundTripSources)
+	csvReader, err := newCSVRowReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources := readRows(t, csvReader, fieldTypes); !reflect.DeepEqual(sources, expected) {
+		t.Errorf("csv round trip returned %v, expected %v", sources, expected)
+	}
+
+	path = filepath.Join(t.TempDir(), "orders.parquet")
+	parquetWriter, err := newParquetRowWriter(path, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeRows(t, parquetWriter, columns, roundTripSources)
+	parquetReader, err := newParquetRowReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sources := readRows(t, parquetReader, fieldTypes); !reflect.DeepEqual(sources, expected) {
+		t.Errorf("parquet round trip returned %v, expected %v", sources, expected)
+	}
+}
+
+func TestParquetColumnMismatch(t *testing.T) {
+	columns := append(append([]column{}, metaColumns...), column{"n", "long"})
+	w, err := newParquetRowWriter(filepath.Join(t.TempDir(), "orders.parquet"), columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	row := map[string]interface{}{"_index": "orders", "n": []interface{}{json.Number("1"), json.Number("2")}}
+	var mismatch *columnMismatchError
+	if err := w.Write(row); !errors.As(err, &mismatch) || mismatch.column.name != "n" {
+		t.Errorf("array in a long column returned %v, expected a mismatch of n", err)
+	}
+	if err := w.Write(map[string]interface{}{"_index": "orders", "n": json.Number("1")}); err != nil {
+		t.Errorf("long in a long column returned %v", err)
+	}
+}
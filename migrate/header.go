@@ -0,0 +1,245 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// DumpVersion is the version of the layout of dumps, a dump of version 1 and
+// later starts with a header line, documents follow one per line
+const DumpVersion = 1
+
+// the header line is {"esm_dump":{...}}, it is no document
+var dumpHeaderPrefix = []byte(`{"esm_dump":`)
+
+// DumpHeader is the metadata of the source indexes of a dump, so that load
+// can create them like --copy_settings and --copy_mappings
+type DumpHeader struct {
+	Version       int                   `json:"version"`
+	SourceVersion string                `json:"source_version"`
+	Created       time.Time             `json:"created"`
+	Indices       map[string]*DumpIndex `json:"indices"`
+}
+
+// DumpIndex is an index of the dump header, mappings are as returned by the
+// source version
+type DumpIndex struct {
+	Settings map[string]interface{} `json:"settings"`
+	Mappings map[string]interface{} `json:"mappings"`
+	Aliases  map[string]interface{} `json:"aliases,omitempty"`
+}
+
+func isDumpHeader(line []byte) bool {
+	return bytes.HasPrefix(line, dumpHeaderPrefix)
+}
+
+// excludedIndex tells if the index is excluded by a -pattern of the index
+// names, like source resolves them for the scroll
+func excludedIndex(indexNames string, name string) bool {
+	for _, pattern := range strings.Split(indexNames, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if !strings.HasPrefix(pattern, "-") {
+			continue
+		}
+		if matched, _ := path.Match(pattern[1:], name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpHeader returns the header of the source indexes dumped, nil when their
+// mappings are not available. Settings and aliases the user may not read are
+// left out.
+func (c *Migrator) dumpHeader() *DumpHeader {
+	_, _, mappings, err := c.SourceESAPI.GetIndexMappings(c.Config.CopyAllIndexes, c.Config.SourceIndexNames)
+	if err != nil {
+		log.Warnf("dump has no header, failed to get the mappings of %s: %v", c.Config.SourceIndexNames, err)
+		return nil
+	}
+
+	header := &DumpHeader{
+		Version:       DumpVersion,
+		SourceVersion: c.sourceVersion,
+		Created:       time.Now().UTC(),
+		Indices:       map[string]*DumpIndex{},
+	}
+	for name, mapping := range *mappings {
+		if excludedIndex(c.Config.SourceIndexNames, name) {
+			continue
+		}
+		index := &DumpIndex{Settings: map[string]interface{}{}, Mappings: map[string]interface{}{}}
+		if m, ok := mapping.(map[string]interface{})["mappings"].(map[string]interface{}); ok {
+			index.Mappings = m
+		}
+		header.Indices[name] = index
+	}
+	if len(header.Indices) == 0 {
+		return header
+	}
+	names := strings.Join((&dumpESAPI{header: header}).names(), ",")
+
+	settings, err := c.SourceESAPI.GetIndexSettings(names)
+	if err != nil {
+		log.Warnf("dump header has no settings, failed to get the settings of %s: %v", names, err)
+	} else {
+		for name, index := range header.Indices {
+			if s, ok := (*settings)[name].(map[string]interface{}); ok {
+				if indexSettings, ok := s["settings"].(map[string]interface{}); ok {
+					index.Settings = indexSettings
+				}
+			}
+		}
+	}
+
+	aliases, err := c.SourceESAPI.GetIndexAliases(names)
+	if err != nil {
+		log.Warnf("dump header has no aliases, failed to get the aliases of %s: %v", names, err)
+	} else {
+		for name, index := range header.Indices {
+			if len(aliases[name].Aliases) > 0 {
+				index.Aliases = aliases[name].Aliases
+			}
+		}
+	}
+	return header
+}
+
+// writeDumpHeader writes the header line of the dump, nothing when the
+// metadata of source is not available
+func (c *Migrator) writeDumpHeader(w io.Writer) error {
+	header := c.dumpHeader()
+	if header == nil {
+		return nil
+	}
+	line, err := json.Marshal(map[string]interface{}{"esm_dump": header})
+	if err != nil {
+		return err
+	}
+	log.Debugf("dump header of %d indexes of %s", len(header.Indices), header.SourceVersion)
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// readDumpHeader returns the header of the input dump, nil for a dump
+// without one. Stdin can't be read twice, the documents are read after the
+// header from m.stdin.
+func (m *Migrator) readDumpHeader(ctx context.Context) (*DumpHeader, error) {
+	f, err := m.openDumpInput(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+	line, err := r.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, err
+	}
+	if m.Config.DumpInputFile == stdio {
+		if isDumpHeader(line) {
+			m.stdin = r
+		} else {
+			m.stdin = io.MultiReader(bytes.NewReader(line), r)
+		}
+	} else {
+		f.Close()
+	}
+
+	if !isDumpHeader(line) {
+		return nil, nil
+	}
+	dump := struct {
+		Header *DumpHeader `json:"esm_dump"`
+	}{}
+	if err := json.Unmarshal(line, &dump); err != nil {
+		return nil, fmt.Errorf("invalid header of %s: %v", m.Config.DumpInputFile, err)
+	}
+	if dump.Header.Version > DumpVersion {
+		return nil, fmt.Errorf("%s is a dump of version %d, this esm reads up to version %d", m.Config.DumpInputFile, dump.Header.Version, DumpVersion)
+	}
+	for _, index := range dump.Header.Indices {
+		if index.Settings == nil {
+			index.Settings = map[string]interface{}{}
+		}
+		if index.Mappings == nil {
+			index.Mappings = map[string]interface{}{}
+		}
+	}
+	return dump.Header, nil
+}
+
+// dumpESAPI is the source of the indexes of a dump header, only the settings
+// and mappings are available
+type dumpESAPI struct {
+	ESAPI
+	header *DumpHeader
+}
+
+func (s *dumpESAPI) names() []string {
+	names := []string{}
+	for name := range s.header.Indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *dumpESAPI) GetIndexMappings(copyAllIndexes bool, indexNames string) (string, int, *Indexes, error) {
+	names := s.names()
+	idxs := Indexes{}
+	for _, name := range names {
+		idxs[name] = map[string]interface{}{"mappings": s.header.Indices[name].Mappings}
+	}
+	return strings.Join(names, ","), len(names), &idxs, nil
+}
+
+func (s *dumpESAPI) GetIndexSettings(indexNames string) (*Indexes, error) {
+	idxs := Indexes{}
+	for _, name := range s.names() {
+		idxs[name] = map[string]interface{}{"settings": s.header.Indices[name].Settings}
+	}
+	return &idxs, nil
+}
+
+// addDumpAliases adds the aliases the indexes of the dump had on source
+func (m *Migrator) addDumpAliases(header *DumpHeader) error {
+	c := m.Config
+	names := (&dumpESAPI{header: header}).names()
+	actions := []map[string]interface{}{}
+	for _, name := range names {
+		target := name
+		if len(c.TargetIndexName) > 0 && len(names) == 1 {
+			target = c.TargetIndexName
+		}
+		aliases := []string{}
+		for alias := range header.Indices[name].Aliases {
+			aliases = append(aliases, alias)
+		}
+		sort.Strings(aliases)
+		for _, alias := range aliases {
+			action := map[string]interface{}{"index": target, "alias": alias}
+			// filter and routing of the alias
+			if definition, ok := header.Indices[name].Aliases[alias].(map[string]interface{}); ok {
+				for k, v := range definition {
+					action[k] = v
+				}
+			}
+			actions = append(actions, map[string]interface{}{"add": action})
+		}
+	}
+	if len(actions) == 0 {
+		return nil
+	}
+	log.Infof("adding %d aliases of the dump", len(actions))
+	return m.TargetESAPI.UpdateAliases(actions)
+}
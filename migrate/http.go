@@ -1,7 +1,8 @@
-package main
+package migrate
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
@@ -129,7 +130,15 @@ var fastHttpClient = &fasthttp.Client{
 	TLSConfig: &tls.Config{InsecureSkipVerify: true},
 }
 
-func DoRequest(compress bool, method string, loadUrl string, auth *Auth, body []byte, proxy string) (string, error) {
+// DoRequest sends the request with fasthttp, it returns as soon as ctx is
+// done, a nil ctx is never done
+func DoRequest(ctx context.Context, compress bool, method string, loadUrl string, auth *Auth, body []byte, proxy string) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 
 	req := fasthttp.AcquireRequest()
 	resp := fasthttp.AcquireResponse()
@@ -182,10 +191,20 @@ func DoRequest(compress bool, method string, loadUrl string, auth *Auth, body []
 		}
 	}
 
-	err := fastHttpClient.Do(req, resp)
+	done := make(chan error, 1)
+	go func() {
+		done <- fastHttpClient.Do(req, resp)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// the request goes on in the background, its response is dropped
+		return "", ctx.Err()
+	}
 
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	if resp == nil {
 		panic("empty response")
@@ -238,7 +257,7 @@ func Request(method string, r string, auth *Auth, body *bytes.Buffer, proxy stri
 
 	respBody, err := io.ReadAll(resp.Body)
 
-	log.Error(util.SubString(string(respBody), 0, 500))
+	log.Trace(util.SubString(string(respBody), 0, 500))
 
 	if err != nil {
 		log.Error(util.SubString(string(err.Error()), 0, 500))
@@ -261,7 +280,7 @@ func DecodeJson(jsonStream string, o interface{}) error {
 	//decoder.
 
 	if err := decoder.Decode(o); err != nil {
-		fmt.Println("error:", err)
+		log.Error("error:", err)
 		return err
 	}
 	return nil
@@ -273,7 +292,7 @@ func DecodeJsonBytes(jsonStream []byte, o interface{}) error {
 	decoder.UseNumber()
 
 	if err := decoder.Decode(o); err != nil {
-		fmt.Println("error:", err)
+		log.Error("error:", err)
 		return err
 	}
 	return nil
@@ -0,0 +1,102 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/raminhz90/esm/util"
+)
+
+// ClusterInfo is what esm inspect shows of a cluster
+type ClusterInfo struct {
+	Host    string      `json:"host"`
+	Version string      `json:"version"`
+	Name    string      `json:"cluster_name"`
+	Status  string      `json:"status"`
+	Indices []IndexInfo `json:"indices"`
+}
+
+type IndexInfo struct {
+	Name       string      `json:"name"`
+	Docs       int64       `json:"docs"`
+	StoreBytes int64       `json:"store_bytes"`
+	Mappings   interface{} `json:"mappings,omitempty"`
+}
+
+// Inspect reads version, health, indexes, sizes and mappings of the source
+// cluster of the config
+func Inspect(ctx context.Context, config *Config) (*ClusterInfo, error) {
+	c := config
+	if len(c.SourceEs) == 0 {
+		return nil, errors.New("no cluster to inspect, type --help for more details")
+	}
+
+	var auth *Auth
+	if len(c.SourceEsAuthStr) > 0 && strings.Contains(c.SourceEsAuthStr, ":") {
+		authArray := strings.Split(c.SourceEsAuthStr, ":")
+		auth = &Auth{User: authArray[0], Pass: authArray[1]}
+	}
+
+	m := NewMigrator(config)
+	version, errs := m.ClusterVersion(c.SourceEs, auth, c.SourceProxy)
+	if errs != nil {
+		return nil, errs[0]
+	}
+	api := newSourceESAPI(ctx, version.Version.Number, c, auth)
+
+	info := &ClusterInfo{Host: c.SourceEs, Version: version.Version.Number}
+	if health := api.ClusterHealth(); health != nil {
+		info.Name = health.Name
+		info.Status = health.Status
+	}
+
+	indexNames, indexCount, mappings, err := api.GetIndexMappings(c.CopyAllIndexes, c.SourceIndexNames)
+	if err != nil {
+		return nil, err
+	}
+	if indexCount == 0 {
+		return info, nil
+	}
+
+	stats, err := api.GetIndexStats(indexNames)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, mapping := range *mappings {
+		index := IndexInfo{Name: name}
+		if fields, ok := mapping.(map[string]interface{}); ok {
+			index.Mappings = fields["mappings"]
+		}
+		if s, ok := stats[name]; ok {
+			index.Docs = s.Docs
+			index.StoreBytes = s.StoreBytes
+		}
+		info.Indices = append(info.Indices, index)
+	}
+	sort.Slice(info.Indices, func(i, j int) bool {
+		return info.Indices[i].Name < info.Indices[j].Name
+	})
+	return info, nil
+}
+
+func (i *ClusterInfo) Print(w io.Writer) {
+	fmt.Fprintf(w, "cluster: %s (%s)\n", i.Host, i.Version)
+	fmt.Fprintf(w, "name: %s, health: %s\n", i.Name, i.Status)
+
+	fmt.Fprintln(w, "\nindexes:")
+	var docs, bytes int64
+	for _, index := range i.Indices {
+		fmt.Fprintf(w, "  %s, docs: %d, size: %s\n", index.Name, index.Docs, formatBytes(index.StoreBytes))
+		if index.Mappings != nil {
+			fmt.Fprintf(w, "     %s\n", util.ToJson(index.Mappings, false))
+		}
+		docs += index.Docs
+		bytes += index.StoreBytes
+	}
+	fmt.Fprintf(w, "  total docs: %d, size: %s\n", docs, formatBytes(bytes))
+}
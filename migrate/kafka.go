@@ -0,0 +1,348 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+	"github.com/segmentio/kafka-go"
+)
+
+var kafkaCompressions = map[string]kafka.Compression{
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
+// kafkaErrorLogger passes the errors of the kafka client to the log
+var kafkaErrorLogger = kafka.LoggerFunc(func(format string, args ...interface{}) {
+	log.Errorf("kafka: "+format, args...)
+})
+
+// kafkaReader consumes --input_topic and commits its offsets, a *kafka.Reader
+type kafkaReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// kafkaWriter produces to --output_topic, a *kafka.Writer
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+func (c *Migrator) kafkaBrokers() []string {
+	return strings.Split(c.Config.KafkaBrokers, ",")
+}
+
+// outputTopic returns the topic the documents of the index are produced to
+func (c *Migrator) outputTopic(index string) string {
+	return strings.ReplaceAll(c.Config.OutputTopic, "{index}", index)
+}
+
+// NewKafkaWorker produces the documents of the queue into kafka until the
+// queue is closed and drained. The message is the document as dumped into a
+// file, keyed by _id so that a document always goes to the same partition.
+func (c *Migrator) NewKafkaWorker(ctx context.Context, pb *pb.ProgressBar) error {
+	w := c.newKafkaWriter()
+	defer w.Close()
+
+	messages := []kafka.Message{}
+	hits := []*Hit{}
+	produce := func() error {
+		if len(messages) == 0 {
+			return nil
+		}
+		err := w.WriteMessages(ctx, messages...)
+		// the writer tells which messages failed, other errors fail all
+		var writeErrors kafka.WriteErrors
+		if !errors.As(err, &writeErrors) {
+			writeErrors = make(kafka.WriteErrors, len(messages))
+			for i := range writeErrors {
+				writeErrors[i] = err
+			}
+		}
+		for i, hit := range hits {
+			if writeErrors[i] != nil {
+				c.Metrics.AddFailed(hit.Index, 1)
+			} else {
+				c.Metrics.AddBulked(hit.Index, 1)
+			}
+			hit.ack(writeErrors[i] == nil)
+		}
+		pb.Add(len(messages) - writeErrors.Count())
+		messages = messages[:0]
+		hits = hits[:0]
+		if err != nil {
+			return fmt.Errorf("failed to produce %d documents to kafka: %v", writeErrors.Count(), err)
+		}
+		return nil
+	}
+
+	timeout := time.NewTimer(c.Config.KafkaBatchTimeout)
+	defer timeout.Stop()
+	for {
+		timeout.Reset(c.Config.KafkaBatchTimeout)
+		select {
+		case hit, open := <-c.Docs.C():
+			if !open {
+				return produce()
+			}
+			if len(hit.Index) == 0 || len(hit.Source) == 0 {
+				log.Errorf("invalid document without _index or _source, index: %q, id: %q", hit.Index, hit.Id)
+				c.Metrics.AddSkipped(hit.Index, 1)
+				c.Docs.Done(hit)
+				hit.ack(true)
+				continue
+			}
+			if !c.transform(hit) {
+				c.Metrics.AddSkipped(hit.Index, 1)
+				c.Docs.Done(hit)
+				hit.ack(true)
+				continue
+			}
+
+			value, err := json.Marshal(hit)
+			c.Docs.Done(hit)
+			if err != nil {
+				c.reportError(fmt.Errorf("invalid _source of document [%s/%s]: %v", hit.Index, hit.Id, err))
+				c.Metrics.AddSkipped(hit.Index, 1)
+				hit.ack(true)
+				continue
+			}
+			messages = append(messages, kafka.Message{
+				Topic: c.outputTopic(hit.Index),
+				Key:   []byte(hit.Id),
+				Value: value,
+			})
+			hits = append(hits, hit)
+			if len(messages) < c.Config.KafkaBatchSize {
+				continue
+			}
+
+		case <-timeout.C:
+		}
+
+		if err := produce(); err != nil {
+			return err
+		}
+	}
+}
+
+// newKafkaWriter returns the writer set on the migrator, or else a writer of
+// --kafka_brokers
+func (c *Migrator) newKafkaWriter() kafkaWriter {
+	if c.kafkaWriter != nil {
+		return c.kafkaWriter
+	}
+	return &kafka.Writer{
+		Addr:     kafka.TCP(c.kafkaBrokers()...),
+		Balancer: &kafka.Hash{},
+		// documents are batched by the worker, the writer sends a batch at
+		// once instead of waiting for the batch of every partition to fill
+		BatchSize:              c.Config.KafkaBatchSize,
+		BatchTimeout:           time.Millisecond,
+		Compression:            kafkaCompressions[c.Config.KafkaCompression],
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+		ErrorLogger:            kafkaErrorLogger,
+	}
+}
+
+// newKafkaReader returns the reader set on the migrator, or else a reader of
+// the consumer group of --input_topic
+func (m *Migrator) newKafkaReader() kafkaReader {
+	if m.kafkaReader != nil {
+		return m.kafkaReader
+	}
+	return kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     m.kafkaBrokers(),
+		GroupID:     m.Config.KafkaGroup,
+		GroupTopics: strings.Split(m.Config.InputTopic, ","),
+		StartOffset: kafka.FirstOffset,
+		ErrorLogger: kafkaErrorLogger,
+	})
+}
+
+// NewKafkaReadWorker puts the messages of --input_topic into the queue until
+// no message arrived for --kafka_idle_timeout or ctx is cancelled. The queue
+// is closed and the worker returns once the documents read are written and
+// their offsets committed.
+func (m *Migrator) NewKafkaReadWorker(ctx context.Context, pb *pb.ProgressBar) error {
+	r := m.newKafkaReader()
+	offsets := &kafkaOffsets{reader: r, partitions: map[kafkaPartition]*partitionOffsets{}}
+
+	// offsets are committed every second, not once per document
+	stopCommits := make(chan struct{})
+	commitsDone := make(chan struct{})
+	go func() {
+		defer close(commitsDone)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := offsets.commit(); err != nil {
+					log.Error(err)
+				}
+			case <-stopCommits:
+				return
+			}
+		}
+	}()
+
+	err := m.consume(ctx, r, offsets, pb)
+
+	m.Docs.Close()
+	offsets.pending.Wait()
+	close(stopCommits)
+	<-commitsDone
+	if commitErr := offsets.commit(); commitErr != nil && err == nil {
+		err = commitErr
+	}
+	if closeErr := r.Close(); closeErr != nil {
+		log.Warn(closeErr)
+	}
+	return err
+}
+
+// consume reads the messages of the topics into the queue
+func (m *Migrator) consume(ctx context.Context, r kafkaReader, offsets *kafkaOffsets, pb *pb.ProgressBar) error {
+	log.Debugf("start consuming %s as group %s", m.Config.InputTopic, m.Config.KafkaGroup)
+	for {
+		fetchCtx, cancel := m.idleContext(ctx)
+		msg, err := r.FetchMessage(fetchCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Infof("no message for %v, stop consuming %s", m.Config.KafkaIdleTimeout, m.Config.InputTopic)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		done := offsets.track(msg)
+		hit, err := kafkaHit(msg)
+		if err != nil {
+			m.reportError(fmt.Errorf("invalid message at offset %d of %s/%d: %v", msg.Offset, msg.Topic, msg.Partition, err))
+			m.Metrics.AddSkipped(msg.Topic, 1)
+			done(true)
+			continue
+		}
+		hit.done = done
+		m.Metrics.AddScrolled(hit.Index, 1)
+		m.Docs.Put(hit)
+		pb.Increment()
+	}
+}
+
+// idleContext is done after --kafka_idle_timeout
+func (m *Migrator) idleContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if m.Config.KafkaIdleTimeout > 0 {
+		return context.WithTimeout(ctx, m.Config.KafkaIdleTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// kafkaHit decodes a message written by --output_topic. Any other json
+// message is the _source of a document of the index named like the topic,
+// with the key of the message as _id.
+func kafkaHit(msg kafka.Message) (*Hit, error) {
+	hit := &Hit{}
+	if err := json.Unmarshal(msg.Value, hit); err != nil {
+		return nil, err
+	}
+	if len(hit.Source) > 0 && len(hit.Index) > 0 {
+		return hit, nil
+	}
+	return &Hit{Index: msg.Topic, Id: string(msg.Key), Source: msg.Value}, nil
+}
+
+type kafkaPartition struct {
+	topic     string
+	partition int
+}
+
+// partitionOffsets are the messages of a partition read but not committed,
+// in order of their offsets
+type partitionOffsets struct {
+	fetched []kafka.Message
+	written map[int64]bool
+	failed  bool
+}
+
+// kafkaOffsets commits the offset of a partition up to the first document
+// not written yet. Once a document failed, the offsets before it are still
+// committed but none after it, the failed and following messages are
+// consumed again by the next run.
+type kafkaOffsets struct {
+	reader     kafkaReader
+	lock       sync.Mutex
+	partitions map[kafkaPartition]*partitionOffsets
+	pending    sync.WaitGroup
+}
+
+// track adds the message and returns the ack of its document
+func (o *kafkaOffsets) track(msg kafka.Message) func(written bool) {
+	key := kafkaPartition{msg.Topic, msg.Partition}
+	o.lock.Lock()
+	p, ok := o.partitions[key]
+	if !ok {
+		p = &partitionOffsets{written: map[int64]bool{}}
+		o.partitions[key] = p
+	}
+	// the value is not needed to commit
+	p.fetched = append(p.fetched, kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset})
+	o.lock.Unlock()
+	o.pending.Add(1)
+
+	return func(written bool) {
+		defer o.pending.Done()
+		o.lock.Lock()
+		defer o.lock.Unlock()
+		if written {
+			p.written[msg.Offset] = true
+		} else if !p.failed {
+			p.failed = true
+			log.Warnf("document at offset %d of %s/%d was not written, later offsets of the partition are not committed any more", msg.Offset, msg.Topic, msg.Partition)
+		}
+	}
+}
+
+// commit commits the offsets of the written documents
+func (o *kafkaOffsets) commit() error {
+	commits := []kafka.Message{}
+	o.lock.Lock()
+	for _, p := range o.partitions {
+		written := 0
+		// a failed document is never written, the commit stops before it
+		for written < len(p.fetched) && p.written[p.fetched[written].Offset] {
+			delete(p.written, p.fetched[written].Offset)
+			written++
+		}
+		if written > 0 {
+			commits = append(commits, p.fetched[written-1])
+			p.fetched = p.fetched[written:]
+		}
+	}
+	o.lock.Unlock()
+
+	if len(commits) == 0 {
+		return nil
+	}
+	if err := o.reader.CommitMessages(context.Background(), commits...); err != nil {
+		return fmt.Errorf("failed to commit offsets: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	goflags "github.com/jessevdk/go-flags"
+	"github.com/raminhz90/esm/esmtest"
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaStub is a topic of a single partition for the reader and a topic
+// for the writer
+type kafkaStub struct {
+	lock     sync.Mutex
+	messages []kafka.Message
+	commits  []int64
+	written  []kafka.Message
+	// messages written with this key fail
+	failKey string
+}
+
+// newKafkaStub returns a partition of orders with the documents 0 to docs-1
+// at their offsets
+func newKafkaStub(docs int) *kafkaStub {
+	k := &kafkaStub{}
+	for i := 0; i < docs; i++ {
+		k.messages = append(k.messages, kafka.Message{
+			Topic:  "orders",
+			Offset: int64(i),
+			Key:    []byte(fmt.Sprint(i)),
+			Value:  []byte(fmt.Sprintf(`{"n":%d}`, i)),
+		})
+	}
+	return k
+}
+
+// FetchMessage returns the next message, or waits for ctx once all are read
+func (k *kafkaStub) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	k.lock.Lock()
+	if len(k.messages) > 0 {
+		msg := k.messages[0]
+		k.messages = k.messages[1:]
+		k.lock.Unlock()
+		return msg, nil
+	}
+	k.lock.Unlock()
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (k *kafkaStub) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	for _, msg := range msgs {
+		k.commits = append(k.commits, msg.Offset)
+	}
+	return nil
+}
+
+func (k *kafkaStub) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	writeErrors := make(kafka.WriteErrors, len(msgs))
+	for i, msg := range msgs {
+		if len(k.failKey) > 0 && string(msg.Key) == k.failKey {
+			writeErrors[i] = errors.New("message too large")
+			continue
+		}
+		k.written = append(k.written, msg)
+	}
+	if writeErrors.Count() > 0 {
+		return writeErrors
+	}
+	return nil
+}
+
+func (k *kafkaStub) Close() error {
+	return nil
+}
+
+// committed returns the offsets committed in order
+func (k *kafkaStub) committed() []int64 {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return append([]int64{}, k.commits...)
+}
+
+// kafkaMigrator returns a migrator of the command line arguments that reads
+// and writes the stub
+func kafkaMigrator(t *testing.T, k *kafkaStub, args ...string) *Migrator {
+	t.Helper()
+	c := &Config{}
+	if _, err := goflags.ParseArgs(c, append([]string{"--kafka_brokers", "localhost:9092", "--kafka_idle_timeout", "100ms"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	m := NewMigrator(c)
+	m.kafkaReader = k
+	m.kafkaWriter = k
+	return m
+}
+
+func TestKafkaOffsets(t *testing.T) {
+	k := newKafkaStub(10)
+	offsets := &kafkaOffsets{reader: k, partitions: map[kafkaPartition]*partitionOffsets{}}
+	acks := []func(bool){}
+	for _, msg := range k.messages {
+		acks = append(acks, offsets.track(msg))
+	}
+
+	// documents are acked out of order, the commit waits for the first
+	for i := 4; i >= 1; i-- {
+		acks[i](true)
+	}
+	if err := offsets.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if commits := k.committed(); len(commits) != 0 {
+		t.Fatalf("offsets %v committed before the first document was written", commits)
+	}
+	acks[0](true)
+	if err := offsets.commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed document stops the commits of the partition
+	acks[5](true)
+	acks[6](false)
+	for i := 7; i < 10; i++ {
+		acks[i](true)
+	}
+	if err := offsets.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := offsets.commit(); err != nil {
+		t.Fatal(err)
+	}
+	if commits := k.committed(); fmt.Sprint(commits) != "[4 5]" {
+		t.Errorf("offsets %v committed, expected [4 5]", commits)
+	}
+	offsets.pending.Wait()
+}
+
+func TestKafkaReadToCluster(t *testing.T) {
+	target := esmtest.NewServer("7.10.2")
+	defer target.Close()
+	k := newKafkaStub(30)
+
+	report, err := kafkaMigrator(t, k, "--input_topic", "orders", "-d", target.URL).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Written != 30 {
+		t.Errorf("%d documents written, expected 30", report.Total.Written)
+	}
+	// the offset of the last document is committed once all are written
+	if commits := k.committed(); len(commits) == 0 || commits[len(commits)-1] != 29 {
+		t.Errorf("offsets %v committed, expected up to 29", commits)
+	}
+}
+
+func TestKafkaReadFailedBulk(t *testing.T) {
+	target := esmtest.NewServer("7.10.2")
+	defer target.Close()
+	target.Fail("_bulk", 0, 500)
+	k := newKafkaStub(30)
+
+	m := kafkaMigrator(t, k, "--input_topic", "orders", "-d", target.URL, "--bulk_retries", "0")
+	done := make(chan error, 1)
+	go func() {
+		_, err := m.Run(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("migration to a failing target succeeded")
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("migration to a failing target didn't return")
+	}
+	if commits := k.committed(); len(commits) != 0 {
+		t.Errorf("offsets %v committed, but no document was written", commits)
+	}
+	if count := target.Count("orders"); count != 0 {
+		t.Errorf("target has %d documents of a failed bulk", count)
+	}
+}
+
+func TestKafkaWriteErrors(t *testing.T) {
+	source := esmtest.NewServer("7.10.2")
+	defer source.Close()
+	source.AddDocs("orders", 10)
+	k := &kafkaStub{failKey: "3"}
+
+	report, err := kafkaMigrator(t, k, "-s", source.URL, "-x", "orders", "--output_topic", "esm-{index}", "--kafka_batch_timeout", "10ms").Run(context.Background())
+	if err == nil {
+		t.Error("migration with a failed message succeeded")
+	}
+	if report.Total.Failed != 1 {
+		t.Errorf("%d documents failed, expected the one of the failed message", report.Total.Failed)
+	}
+	for _, msg := range k.written {
+		if msg.Topic != "esm-orders" || string(msg.Key) == "3" {
+			t.Errorf("message %s of %s written", msg.Key, msg.Topic)
+		}
+	}
+}
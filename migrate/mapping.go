@@ -0,0 +1,158 @@
+package migrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func majorVersion(version string) int {
+	major, _ := strconv.Atoi(strings.Split(version, ".")[0])
+	return major
+}
+
+// translateMappings converts the mappings of an index of the source version
+// to the target version. Mappings of 7.x and later have no types, types are
+// removed or added, string fields become text or keyword and back, and the
+// meta fields and parameters the target doesn't know are dropped. typeName
+// renames a single type on older targets.
+func translateMappings(mappings map[string]interface{}, source string, target string, typeName string) (map[string]interface{}, error) {
+	from, to := majorVersion(source), majorVersion(target)
+	if from == to || from == 0 || to == 0 {
+		return mappings, nil
+	}
+
+	types := map[string]map[string]interface{}{}
+	// 6.x returns the mappings without a type too
+	if _, typeless := mappings["properties"].(map[string]interface{}); from >= 7 || typeless {
+		types[""] = mappings
+	} else {
+		for name, mapping := range mappings {
+			if m, ok := mapping.(map[string]interface{}); ok {
+				types[name] = m
+			}
+		}
+	}
+	if to >= 7 {
+		delete(types, "_default_")
+	}
+	for _, mapping := range types {
+		translateMapping(mapping, to)
+	}
+
+	if to >= 7 {
+		if len(types) > 1 {
+			return nil, fmt.Errorf("mappings of %d types can't be written to %s, it supports one type only", len(types), target)
+		}
+		for _, mapping := range types {
+			return mapping, nil
+		}
+		return map[string]interface{}{}, nil
+	}
+
+	defaultType := "doc"
+	if to == 6 {
+		defaultType = "_doc"
+	}
+	typed := map[string]interface{}{}
+	for name, mapping := range types {
+		switch {
+		case len(typeName) > 0 && len(types) == 1:
+			// a single type is renamed by --type_override like its documents
+			name = typeName
+		case len(name) == 0:
+			name = defaultType
+		}
+		typed[name] = mapping
+	}
+	return typed, nil
+}
+
+// translateMapping converts the mapping of a type
+func translateMapping(mapping map[string]interface{}, to int) {
+	if to >= 5 {
+		delete(mapping, "_timestamp")
+		delete(mapping, "_ttl")
+	}
+	if to >= 7 {
+		delete(mapping, "_all")
+	}
+	if properties, ok := mapping["properties"].(map[string]interface{}); ok {
+		translateProperties(properties, to)
+	}
+	if templates, ok := mapping["dynamic_templates"].([]interface{}); ok {
+		for _, template := range templates {
+			named, _ := template.(map[string]interface{})
+			for _, t := range named {
+				definition, _ := t.(map[string]interface{})
+				if field, ok := definition["mapping"].(map[string]interface{}); ok {
+					translateField(field, to)
+				}
+			}
+		}
+	}
+}
+
+func translateProperties(properties map[string]interface{}, to int) {
+	for _, property := range properties {
+		if field, ok := property.(map[string]interface{}); ok {
+			translateField(field, to)
+		}
+	}
+}
+
+// translateField converts a field with its objects and multi fields. Before
+// 5.x text and keyword were analyzed and not analyzed strings, index was
+// analyzed, not_analyzed or no instead of a bool and norms an object.
+func translateField(field map[string]interface{}, to int) {
+	fieldType, _ := field["type"].(string)
+	if to >= 5 {
+		index, _ := field["index"].(string)
+		if fieldType == "string" {
+			if index == "not_analyzed" || index == "no" {
+				field["type"] = "keyword"
+			} else {
+				field["type"] = "text"
+			}
+		}
+		switch index {
+		case "no":
+			field["index"] = false
+		case "analyzed", "not_analyzed":
+			delete(field, "index")
+		}
+		if norms, ok := field["norms"].(map[string]interface{}); ok {
+			field["norms"] = norms["enabled"] != false
+		}
+		if _, ok := field["fielddata"].(map[string]interface{}); ok {
+			delete(field, "fielddata")
+		}
+	} else {
+		index, isBool := field["index"].(bool)
+		switch fieldType {
+		case "text":
+			field["type"] = "string"
+		case "keyword":
+			field["type"] = "string"
+			field["index"] = "not_analyzed"
+		}
+		if isBool && !index {
+			field["index"] = "no"
+		} else if isBool && fieldType != "keyword" {
+			delete(field, "index")
+		}
+		if norms, ok := field["norms"].(bool); ok {
+			field["norms"] = map[string]interface{}{"enabled": norms}
+		}
+	}
+	if to >= 7 {
+		delete(field, "include_in_all")
+	}
+
+	if properties, ok := field["properties"].(map[string]interface{}); ok {
+		translateProperties(properties, to)
+	}
+	if fields, ok := field["fields"].(map[string]interface{}); ok {
+		translateProperties(fields, to)
+	}
+}
@@ -0,0 +1,337 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+var bulkLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics collects the progress of a migration, exposed through /metrics
+// and /status
+type Metrics struct {
+	lock          sync.Mutex
+	start         time.Time
+	queues        map[*DocQueue]bool
+	indices       map[string]*indexMetrics
+	sources       map[string]string
+	bulkErrors    map[int]int64
+	scrollErrors  int64
+	scrollRetries int64
+
+	latencyCounts []int64
+	latencySum    float64
+	latencyCount  int64
+}
+
+type indexMetrics struct {
+	scrolled int64
+	bulked   int64
+	failed   int64
+	skipped  int64
+	first    time.Time
+	last     time.Time
+}
+
+type IndexStatus struct {
+	Scrolled int64     `json:"scrolled"`
+	Bulked   int64     `json:"bulked"`
+	Failed   int64     `json:"failed"`
+	Skipped  int64     `json:"skipped"`
+	Started  time.Time `json:"started"`
+	Updated  time.Time `json:"updated"`
+}
+
+type MigrationStatus struct {
+	Started         time.Time               `json:"started"`
+	ElapsedSeconds  float64                 `json:"elapsed_seconds"`
+	DocsScrolled    int64                   `json:"docs_scrolled"`
+	DocsBulked      int64                   `json:"docs_bulked"`
+	DocsFailed      int64                   `json:"docs_failed"`
+	DocsSkipped     int64                   `json:"docs_skipped"`
+	ScrollErrors    int64                   `json:"scroll_errors"`
+	ScrollRetries   int64                   `json:"scroll_retries"`
+	BulkErrors      map[string]int64        `json:"bulk_errors"`
+	BulkRequests    int64                   `json:"bulk_requests"`
+	DocChanDepth    int                     `json:"doc_chan_depth"`
+	DocChanCapacity int                     `json:"doc_chan_capacity"`
+	BufferedBytes   int64                   `json:"buffered_bytes"`
+	BufferLimit     int64                   `json:"buffer_limit_bytes"`
+	Indices         map[string]*IndexStatus `json:"indices"`
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		start:         time.Now(),
+		queues:        map[*DocQueue]bool{},
+		indices:       map[string]*indexMetrics{},
+		sources:       map[string]string{},
+		bulkErrors:    map[int]int64{},
+		latencyCounts: make([]int64, len(bulkLatencyBuckets)),
+	}
+}
+
+// TrackQueue adds the queue to the reported buffered documents, jobs running
+// in parallel have one queue each
+func (m *Metrics) TrackQueue(queue *DocQueue) {
+	m.lock.Lock()
+	m.queues[queue] = true
+	m.lock.Unlock()
+}
+
+func (m *Metrics) UntrackQueue(queue *DocQueue) {
+	m.lock.Lock()
+	delete(m.queues, queue)
+	m.lock.Unlock()
+}
+
+// index returns the metrics of a source index, the target index name is
+// translated back if it was renamed, must be called with the lock held
+func (m *Metrics) index(name string) *indexMetrics {
+	if source, ok := m.sources[name]; ok {
+		name = source
+	}
+	idx, ok := m.indices[name]
+	if !ok {
+		idx = &indexMetrics{first: time.Now()}
+		m.indices[name] = idx
+	}
+	idx.last = time.Now()
+	return idx
+}
+
+// MapIndex records that documents of the source index are written into
+// the target index
+func (m *Metrics) MapIndex(target, source string) {
+	m.lock.Lock()
+	m.sources[target] = source
+	m.lock.Unlock()
+}
+
+func (m *Metrics) AddScrolled(index string, n int) {
+	m.lock.Lock()
+	m.index(index).scrolled += int64(n)
+	m.lock.Unlock()
+}
+
+func (m *Metrics) AddBulked(index string, n int) {
+	m.lock.Lock()
+	m.index(index).bulked += int64(n)
+	m.lock.Unlock()
+}
+
+// AddFailed counts documents that could not be written into target
+func (m *Metrics) AddFailed(index string, n int) {
+	m.lock.Lock()
+	m.index(index).failed += int64(n)
+	m.lock.Unlock()
+}
+
+// AddSkipped counts documents that were dropped before reaching target
+func (m *Metrics) AddSkipped(index string, n int) {
+	m.lock.Lock()
+	m.index(index).skipped += int64(n)
+	m.lock.Unlock()
+}
+
+// AddScrollError counts scroll requests that failed for good and shard
+// failures
+func (m *Metrics) AddScrollError() {
+	m.lock.Lock()
+	m.scrollErrors++
+	m.lock.Unlock()
+}
+
+// AddScrollRetry counts failed scroll requests that are retried
+func (m *Metrics) AddScrollRetry() {
+	m.lock.Lock()
+	m.scrollRetries++
+	m.lock.Unlock()
+}
+
+// AddBulkError counts failed documents by http status, 0 means the request
+// didn't reach the target
+func (m *Metrics) AddBulkError(status int, n int) {
+	m.lock.Lock()
+	m.bulkErrors[status] += int64(n)
+	m.lock.Unlock()
+}
+
+func (m *Metrics) ObserveBulkLatency(latency time.Duration) {
+	seconds := latency.Seconds()
+	m.lock.Lock()
+	for i, bound := range bulkLatencyBuckets {
+		if seconds <= bound {
+			m.latencyCounts[i]++
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+	m.lock.Unlock()
+}
+
+// Status returns a snapshot of the metrics
+func (m *Metrics) Status() *MigrationStatus {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	status := &MigrationStatus{
+		Started:        m.start,
+		ElapsedSeconds: time.Since(m.start).Seconds(),
+		BulkErrors:     map[string]int64{},
+		BulkRequests:   m.latencyCount,
+		ScrollErrors:   m.scrollErrors,
+		ScrollRetries:  m.scrollRetries,
+		Indices:        map[string]*IndexStatus{},
+	}
+	for queue := range m.queues {
+		status.DocChanDepth += queue.Len()
+		status.DocChanCapacity += queue.Cap()
+		status.BufferedBytes += queue.Bytes()
+		status.BufferLimit += queue.Limit()
+	}
+	for name, idx := range m.indices {
+		status.DocsScrolled += idx.scrolled
+		status.DocsBulked += idx.bulked
+		status.DocsFailed += idx.failed
+		status.DocsSkipped += idx.skipped
+		status.Indices[name] = &IndexStatus{
+			Scrolled: idx.scrolled,
+			Bulked:   idx.bulked,
+			Failed:   idx.failed,
+			Skipped:  idx.skipped,
+			Started:  idx.first,
+			Updated:  idx.last,
+		}
+	}
+	for code, n := range m.bulkErrors {
+		status.BulkErrors[strconv.Itoa(code)] = n
+	}
+	return status
+}
+
+// WritePrometheus writes the metrics in prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	status := m.Status()
+
+	fmt.Fprintln(w, "# HELP esm_docs_scrolled_total Documents read from source.")
+	fmt.Fprintln(w, "# TYPE esm_docs_scrolled_total counter")
+	fmt.Fprintf(w, "esm_docs_scrolled_total %d\n", status.DocsScrolled)
+
+	fmt.Fprintln(w, "# HELP esm_docs_bulked_total Documents indexed into target.")
+	fmt.Fprintln(w, "# TYPE esm_docs_bulked_total counter")
+	fmt.Fprintf(w, "esm_docs_bulked_total %d\n", status.DocsBulked)
+
+	fmt.Fprintln(w, "# HELP esm_docs_failed_total Documents failed to index after retries.")
+	fmt.Fprintln(w, "# TYPE esm_docs_failed_total counter")
+	fmt.Fprintf(w, "esm_docs_failed_total %d\n", status.DocsFailed)
+
+	fmt.Fprintln(w, "# HELP esm_docs_skipped_total Documents dropped before indexing.")
+	fmt.Fprintln(w, "# TYPE esm_docs_skipped_total counter")
+	fmt.Fprintf(w, "esm_docs_skipped_total %d\n", status.DocsSkipped)
+
+	fmt.Fprintln(w, "# HELP esm_scroll_errors_total Failed scroll requests after retries and shard failures.")
+	fmt.Fprintln(w, "# TYPE esm_scroll_errors_total counter")
+	fmt.Fprintf(w, "esm_scroll_errors_total %d\n", status.ScrollErrors)
+
+	fmt.Fprintln(w, "# HELP esm_scroll_retries_total Failed scroll requests that were retried.")
+	fmt.Fprintln(w, "# TYPE esm_scroll_retries_total counter")
+	fmt.Fprintf(w, "esm_scroll_retries_total %d\n", status.ScrollRetries)
+
+	fmt.Fprintln(w, "# HELP esm_bulk_errors_total Documents failed to index, by http status.")
+	fmt.Fprintln(w, "# TYPE esm_bulk_errors_total counter")
+	for _, code := range sortedKeys(status.BulkErrors) {
+		fmt.Fprintf(w, "esm_bulk_errors_total{status=%q} %d\n", code, status.BulkErrors[code])
+	}
+
+	fmt.Fprintln(w, "# HELP esm_doc_chan_depth Documents buffered between readers and writers.")
+	fmt.Fprintln(w, "# TYPE esm_doc_chan_depth gauge")
+	fmt.Fprintf(w, "esm_doc_chan_depth %d\n", status.DocChanDepth)
+	fmt.Fprintln(w, "# HELP esm_doc_chan_capacity Capacity of the document buffer.")
+	fmt.Fprintln(w, "# TYPE esm_doc_chan_capacity gauge")
+	fmt.Fprintf(w, "esm_doc_chan_capacity %d\n", status.DocChanCapacity)
+	fmt.Fprintln(w, "# HELP esm_buffered_bytes Bytes of the documents buffered between readers and writers.")
+	fmt.Fprintln(w, "# TYPE esm_buffered_bytes gauge")
+	fmt.Fprintf(w, "esm_buffered_bytes %d\n", status.BufferedBytes)
+	fmt.Fprintln(w, "# HELP esm_buffer_limit_bytes Limit of the document buffer in bytes, readers wait above it.")
+	fmt.Fprintln(w, "# TYPE esm_buffer_limit_bytes gauge")
+	fmt.Fprintf(w, "esm_buffer_limit_bytes %d\n", status.BufferLimit)
+
+	fmt.Fprintln(w, "# HELP esm_index_docs_scrolled_total Documents read from source, by index.")
+	fmt.Fprintln(w, "# TYPE esm_index_docs_scrolled_total counter")
+	indices := make([]string, 0, len(status.Indices))
+	for name := range status.Indices {
+		indices = append(indices, name)
+	}
+	sort.Strings(indices)
+	for _, name := range indices {
+		if status.Indices[name].Scrolled > 0 {
+			fmt.Fprintf(w, "esm_index_docs_scrolled_total{index=%q} %d\n", name, status.Indices[name].Scrolled)
+		}
+	}
+	fmt.Fprintln(w, "# HELP esm_index_docs_bulked_total Documents indexed into target, by index.")
+	fmt.Fprintln(w, "# TYPE esm_index_docs_bulked_total counter")
+	for _, name := range indices {
+		if status.Indices[name].Bulked > 0 {
+			fmt.Fprintf(w, "esm_index_docs_bulked_total{index=%q} %d\n", name, status.Indices[name].Bulked)
+		}
+	}
+
+	m.lock.Lock()
+	fmt.Fprintln(w, "# HELP esm_bulk_latency_seconds Latency of bulk requests.")
+	fmt.Fprintln(w, "# TYPE esm_bulk_latency_seconds histogram")
+	for i, bound := range bulkLatencyBuckets {
+		fmt.Fprintf(w, "esm_bulk_latency_seconds_bucket{le=\"%g\"} %d\n", bound, m.latencyCounts[i])
+	}
+	fmt.Fprintf(w, "esm_bulk_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(w, "esm_bulk_latency_seconds_sum %g\n", m.latencySum)
+	fmt.Fprintf(w, "esm_bulk_latency_seconds_count %d\n", m.latencyCount)
+	m.lock.Unlock()
+}
+
+func sortedKeys(in map[string]int64) []string {
+	keys := make([]string, 0, len(in))
+	for k := range in {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *Metrics) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WritePrometheus(w)
+}
+
+func (m *Metrics) statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(m.Status())
+}
+
+// StartHTTPServer serves pprof, /metrics and /status on the given address
+func (m *Metrics) StartHTTPServer(listen string) {
+	log.Infof("http server listen at: http://%s/", listen)
+	mux := http.NewServeMux()
+
+	// register pprof handler
+	mux.HandleFunc("/debug/pprof/", func(w http.ResponseWriter, r *http.Request) {
+		http.DefaultServeMux.ServeHTTP(w, r)
+	})
+
+	// register metrics handler
+	mux.HandleFunc("/metrics", m.metricsHandler)
+	mux.HandleFunc("/status", m.statusHandler)
+
+	err := http.ListenAndServe(listen, mux)
+	log.Debug("stop http server: ", err)
+}
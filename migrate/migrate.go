@@ -0,0 +1,985 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+	goflags "github.com/jessevdk/go-flags"
+	"golang.org/x/sync/errgroup"
+)
+
+// Hooks are optional callbacks of a migration, they are called from the
+// readers and writers and must be safe for concurrent use
+type Hooks struct {
+	// Progress receives the counters every second and once at the end
+	Progress func(status *MigrationStatus)
+	// Error receives failed documents, bulk requests and read tasks
+	Error func(err error)
+	// Transform may change a document before it is written, documents it
+	// returns false for are dropped and counted as skipped
+	Transform func(hit *Hit) bool
+}
+
+// NewConfig returns a config with the defaults of the command line options
+func NewConfig() (*Config, error) {
+	c := &Config{}
+	if _, err := goflags.ParseArgs(c, []string{}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewMigrator creates a migrator of the config with its own metrics
+func NewMigrator(config *Config) *Migrator {
+	return &Migrator{Config: config, Metrics: NewMetrics(), Restore: &IndexRestore{}}
+}
+
+// Run migrates as configured and returns the report of the migration, the
+// error is fatal, failed documents only show in the report
+func Run(ctx context.Context, config *Config) (*Report, error) {
+	return NewMigrator(config).Run(ctx)
+}
+
+// Run runs the migration until it is finished or stopped. Cancelling ctx
+// aborts the scroll and bulk requests in flight, while Stop lets the writers
+// flush the documents already read.
+func (m *Migrator) Run(ctx context.Context) (*Report, error) {
+	if m.Metrics == nil {
+		m.Metrics = NewMetrics()
+	}
+
+	stopCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	m.stopLock.Lock()
+	m.stopReading = stop
+	if m.stopCause != nil {
+		stop(m.stopCause)
+	}
+	m.stopLock.Unlock()
+
+	progressDone := make(chan struct{})
+	wg := sync.WaitGroup{}
+	if m.Hooks.Progress != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.reportProgress(progressDone)
+		}()
+	}
+
+	err := m.migrate(stopCtx, ctx)
+	if err != nil && stopCtx.Err() != nil {
+		// requests failing because of the stop are no failure of their own
+		err = ErrInterrupted
+	}
+
+	close(progressDone)
+	wg.Wait()
+	return m.Metrics.Report(err), err
+}
+
+// Stop stops reading, the documents already read are written and Run
+// returns ErrInterrupted, a migrator stopped before Run doesn't start
+func (m *Migrator) Stop(cause error) {
+	if cause == nil {
+		cause = ErrInterrupted
+	}
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	if m.stopCause == nil {
+		m.stopCause = cause
+	}
+	if m.stopReading != nil {
+		m.stopReading(m.stopCause)
+	}
+}
+
+func (m *Migrator) reportProgress(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Hooks.Progress(m.Metrics.Status())
+		case <-done:
+			m.Hooks.Progress(m.Metrics.Status())
+			return
+		}
+	}
+}
+
+// reportError logs the error and passes it to the error hook
+func (m *Migrator) reportError(err error) {
+	log.Error(err)
+	if m.Hooks.Error != nil {
+		m.Hooks.Error(err)
+	}
+}
+
+// transform passes the document to the transform hook, false drops it
+func (m *Migrator) transform(hit *Hit) bool {
+	if m.Hooks.Transform == nil {
+		return true
+	}
+	return m.Hooks.Transform(hit)
+}
+
+// migrate runs the whole migration, the returned error is fatal. Reading
+// stops once ctx is done, requests to target are cancelled by requestCtx
+// only, so that the documents already read are still written.
+func (m *Migrator) migrate(ctx context.Context, requestCtx context.Context) (err error) {
+	c := m.Config
+
+	if len(c.SourceEs) == 0 && len(c.DumpInputFile) == 0 && len(c.InputTopic) == 0 {
+		return errors.New("no input, type --help for more details")
+	}
+	if len(c.TargetEs) == 0 && len(c.DumpOutFile) == 0 && len(c.OutputTopic) == 0 {
+		return errors.New("no output, type --help for more details")
+	}
+	if (len(c.InputTopic) > 0 || len(c.OutputTopic) > 0) && len(c.KafkaBrokers) == 0 {
+		return errors.New("--input_topic and --output_topic need --kafka_brokers")
+	}
+
+	if c.OutputFormat != "" && c.OutputFormat != "dump" && (len(c.DumpOutFile) == 0 || len(c.SourceEs) == 0) {
+		return fmt.Errorf("--output_format=%s exports documents of a source cluster, it needs --source and --output_file", c.OutputFormat)
+	}
+
+	if (isS3Path(c.DumpOutFile) && c.OutputFormat != "" && c.OutputFormat != "dump") || (isS3Path(c.DumpInputFile) && (c.InputFileType == "csv" || c.InputFileType == "parquet")) {
+		return errors.New("only dumps are written to and read from s3, csv and parquet files must be local")
+	}
+	if (c.DumpOutFile == stdio && c.OutputFormat != "" && c.OutputFormat != "dump") || (c.DumpInputFile == stdio && (c.InputFileType == "csv" || c.InputFileType == "parquet")) {
+		return errors.New("only dumps are written to stdout and read from stdin, csv and parquet files must be local")
+	}
+	if (isS3Path(c.DumpOutFile) || isS3Path(c.DumpInputFile)) && c.S3PartSize < 5 {
+		return errors.New("--s3_part_size must be at least 5 MB")
+	}
+
+	if c.SourceEs == c.TargetEs && c.SourceIndexNames == c.TargetIndexName {
+		return errors.New("migration output is the same as the output")
+	}
+
+	if c.Backup == "snapshot" && len(c.SnapshotRepository) == 0 {
+		return errors.New("--backup=snapshot needs --snapshot_repository")
+	}
+	if c.Mode == "snapshot" {
+		if len(c.SourceEs) == 0 || len(c.TargetEs) == 0 {
+			return errors.New("--mode=snapshot needs a source and a target cluster")
+		}
+		if len(c.SnapshotRepository) == 0 {
+			return errors.New("--mode=snapshot needs --snapshot_repository")
+		}
+		if len(c.Query) > 0 || len(c.Fields) > 0 || len(c.RenameFields) > 0 {
+			return errors.New("--mode=snapshot copies whole indexes, --query, --fields and --rename can't be used")
+		}
+	}
+	if c.Mode == "reindex_remote" {
+		if len(c.SourceEs) == 0 || len(c.TargetEs) == 0 {
+			return errors.New("--mode=reindex_remote needs a source and a target cluster")
+		}
+		if len(c.RenameFields) > 0 || len(c.OverrideTypeName) > 0 || c.RegenerateID {
+			return errors.New("--mode=reindex_remote writes documents as they are, --rename, --type_override and --regenerate_id can't be used")
+		}
+		if c.Split == "shard" || c.ScrollSliceSize > 1 {
+			return errors.New("--mode=reindex_remote can't be sliced, split by --split=index or --split=range")
+		}
+	}
+
+	if c.DryRun {
+		m.Plan = &Plan{}
+	}
+
+	var showBar bool = false
+	if c.DryRun {
+		showBar = false
+	} else if m.Progress != nil {
+		// jobs running in batch share the progress bars of the main
+		showBar = false
+	} else {
+		showBar = IsTerminal(c.Console())
+	}
+
+	var indexSettingsOverride map[string]interface{}
+	if len(c.IndexSettings) > 0 {
+		if err := DecodeJson(c.IndexSettings, &indexSettingsOverride); err != nil {
+			return fmt.Errorf("invalid index settings: %v", err)
+		}
+		indexSettingsOverride = flattenIndexSettings(indexSettingsOverride)
+	}
+	if len(c.Alias) > 0 && len(c.TargetEs) == 0 {
+		return errors.New("--alias needs a target cluster")
+	}
+	// the indexes of a load are known from the header of a dump only
+	if len(c.Alias) > 0 && len(c.SourceEs) == 0 && len(c.TargetIndexName) == 0 && (len(c.DumpInputFile) == 0 || c.InputFileType != "dump") {
+		return errors.New("--alias of documents not read from a cluster or a dump needs --dest_index")
+	}
+	if c.Verify && len(c.SourceEs) == 0 {
+		return errors.New("--verify needs a source cluster")
+	}
+	if c.Split == "range" && len(c.SplitField) == 0 {
+		return errors.New("--split=range needs --split_field")
+	}
+
+	// target index settings changed for the migration are restored when the
+	// migration ends, the alias is switched only if it succeeded
+	if m.Restore == nil {
+		m.Restore = &IndexRestore{}
+	}
+	defer func() {
+		if restoreErr := m.recoveryIndexSettings(); restoreErr != nil {
+			log.Error(restoreErr)
+			if err == nil {
+				err = restoreErr
+			}
+		}
+		if err == nil && (len(c.Alias) > 0 || c.Verify && len(c.TargetEs) > 0 && !c.DryRun) {
+			var indices []string
+			if indices, err = m.targetIndexNames(); err != nil {
+				return
+			}
+			if len(c.Alias) > 0 {
+				err = m.cutoverAlias(indices)
+			} else {
+				err = m.verifyIndices(indices)
+			}
+		}
+	}()
+
+	if c.RepeatOutputTimes < 1 {
+		c.RepeatOutputTimes = 1
+	} else {
+		log.Info("source data will repeat send to target: ", c.RepeatOutputTimes, " times, the document id will be regenerated.")
+	}
+
+	if c.RepeatOutputTimes > 0 {
+
+		for i := 0; i < c.RepeatOutputTimes; i++ {
+
+			if ctx.Err() != nil {
+				return ErrInterrupted
+			}
+
+			if c.RepeatOutputTimes > 1 {
+				log.Info("repeat round: ", i+1)
+			}
+
+			// buffer between readers and workers, bounded by documents and bytes
+			m.Docs = NewDocQueue(c.BufferCount, int64(c.BufferMB)*1024*1024)
+			m.Metrics.TrackQueue(m.Docs)
+
+			var sourceVersion string
+			// create a progressbar and start a docCount
+			var outputBar *pb.ProgressBar = pb.New(1).Prefix("Output ")
+
+			var fetchBar = pb.New(1).Prefix("Scroll")
+
+			if m.Progress != nil {
+				fetchBar = m.Progress.FetchBar
+				outputBar = m.Progress.OutputBar
+			}
+
+			// the input is read once the target is prepared, readers close the
+			// queue when they are done and writers run until it is drained
+			var readInput func(ctx context.Context) error
+			var planScroll func() error
+			lineCount := 0
+
+			//dealing with input
+			if len(c.SourceEs) > 0 {
+				//dealing with basic auth
+				if len(c.SourceEsAuthStr) > 0 && strings.Contains(c.SourceEsAuthStr, ":") {
+					authArray := strings.Split(c.SourceEsAuthStr, ":")
+					auth := Auth{User: authArray[0], Pass: authArray[1]}
+					m.SourceAuth = &auth
+				}
+
+				//get source es version
+				srcESVersion, errs := m.ClusterVersion(c.SourceEs, m.SourceAuth, m.Config.SourceProxy)
+				if errs != nil {
+					return errs[0]
+				}
+				if c.DryRun {
+					m.Plan.SourceVersion = srcESVersion.Version.Number
+				}
+				sourceVersion = srcESVersion.Version.Number
+				m.sourceVersion = sourceVersion
+				m.SourceESAPI = newSourceESAPI(ctx, srcESVersion.Version.Number, c, m.SourceAuth)
+
+				if c.ScrollSliceSize < 1 {
+					c.ScrollSliceSize = 1
+				}
+
+				// no scroll is opened in dry run or without documents, nor by
+				// the modes moving documents without esm unless the snapshot
+				// falls back to scroll
+				planScroll = func() error {
+					if c.DryRun || c.SkipDocuments {
+						return nil
+					}
+					tasks, err := m.planReads()
+					if err != nil {
+						return err
+					}
+
+					totalSize, err := m.SourceESAPI.Count(c.SourceIndexNames, c.Query)
+					if err != nil {
+						return err
+					}
+					if totalSize == 0 {
+						return errors.New("can't find documents from source.")
+					}
+
+					readInput = func(ctx context.Context) error {
+						// all tasks finished, close the queue
+						defer m.Docs.Close()
+						errs := m.readTasks(ctx, tasks, fetchBar)
+						if showBar {
+							fetchBar.Finish()
+						}
+						return errors.Join(errs...)
+					}
+
+					if m.Progress != nil {
+						m.Progress.AddTotal(totalSize)
+					} else {
+						fetchBar.Total = int64(totalSize)
+						outputBar.Total = int64(totalSize)
+					}
+					return nil
+				}
+				if c.Mode != "snapshot" && c.Mode != "reindex_remote" {
+					if err := planScroll(); err != nil {
+						return err
+					}
+				}
+
+			} else if isS3Path(c.DumpInputFile) || c.DumpInputFile == stdio {
+				// the number of documents of the objects or of stdin is
+				// unknown, the bars only count
+				if m.Progress == nil {
+					fetchBar = pb.New(0).Prefix("Read")
+					outputBar = pb.New(0).Prefix("Output ")
+				}
+				readInput = func(ctx context.Context) error {
+					return m.NewFileReadWorker(ctx, fetchBar)
+				}
+
+			} else if len(c.DumpInputFile) > 0 && c.InputFileType == "parquet" {
+				if lineCount, err = parquetRowCount(c.DumpInputFile); err != nil {
+					return err
+				}
+				log.Trace("parquet rows,", lineCount)
+
+				if m.Progress != nil {
+					m.Progress.AddTotal(lineCount)
+				} else {
+					fetchBar = pb.New(lineCount).Prefix("Read")
+					outputBar = pb.New(lineCount).Prefix("Output ")
+				}
+
+				readInput = func(ctx context.Context) error {
+					return m.NewFileReadWorker(ctx, fetchBar)
+				}
+
+			} else if len(c.DumpInputFile) > 0 && c.DryRun {
+				// the plan tells the number of documents, the file is
+				// only read to count its lines
+				if lineCount, err = countLines(c.DumpInputFile); err != nil {
+					return err
+				}
+				// the header of a csv file or of a dump is no document
+				if c.InputFileType == "csv" && lineCount > 0 {
+					lineCount--
+				}
+				if c.InputFileType == "dump" && lineCount > 0 {
+					if header, err := m.readDumpHeader(ctx); err == nil && header != nil {
+						lineCount--
+					}
+				}
+				log.Trace("file line,", lineCount)
+
+			} else if len(c.DumpInputFile) > 0 {
+				// the file is read once, the progress is the bytes read of it
+				info, err := os.Stat(c.DumpInputFile)
+				if err != nil {
+					return err
+				}
+				if m.Progress == nil {
+					fetchBar = pb.New64(info.Size()).SetUnits(pb.U_BYTES).Prefix("Read")
+					outputBar = pb.New(0).Prefix("Output ")
+				}
+
+				readInput = func(ctx context.Context) error {
+					return m.NewFileReadWorker(ctx, fetchBar)
+				}
+
+			} else if len(c.InputTopic) > 0 {
+				// the number of messages is unknown, the bars only count
+				if m.Progress == nil {
+					fetchBar = pb.New(0).Prefix("Consume")
+					outputBar = pb.New(0).Prefix("Output ")
+				}
+				readInput = func(ctx context.Context) error {
+					return m.NewKafkaReadWorker(ctx, fetchBar)
+				}
+			}
+
+			var pool *pb.Pool
+			if showBar {
+
+				// start pool
+				pool = pb.NewPool(fetchBar, outputBar)
+				pool.Output = c.Console()
+				if err = pool.Start(); err != nil {
+					return err
+				}
+			}
+
+			//dealing with output
+			if len(c.TargetEs) > 0 {
+				if len(c.TargetEsAuthStr) > 0 && strings.Contains(c.TargetEsAuthStr, ":") {
+					authArray := strings.Split(c.TargetEsAuthStr, ":")
+					auth := Auth{User: authArray[0], Pass: authArray[1]}
+					m.TargetAuth = &auth
+				}
+
+				//get target es version
+				descESVersion, errs := m.ClusterVersion(c.TargetEs, m.TargetAuth, m.Config.TargetProxy)
+				if errs != nil {
+					return errs[0]
+				}
+				if strings.HasPrefix(descESVersion.Version.Number, "9.") {
+					log.Debug("target es is V9,", descESVersion.Version.Number)
+					api := new(ESAPIV8)
+					api.Host = c.TargetEs
+					api.Context = requestCtx
+					api.Auth = m.TargetAuth
+					api.HttpProxy = m.Config.TargetProxy
+					m.TargetESAPI = api
+				} else if strings.HasPrefix(descESVersion.Version.Number, "8.") {
+					log.Debug("target es is V8,", descESVersion.Version.Number)
+					api := new(ESAPIV8)
+					api.Host = c.TargetEs
+					api.Context = requestCtx
+					api.Auth = m.TargetAuth
+					api.HttpProxy = m.Config.TargetProxy
+					m.TargetESAPI = api
+				} else if strings.HasPrefix(descESVersion.Version.Number, "7.") {
+					log.Debug("target es is V7,", descESVersion.Version.Number)
+					api := new(ESAPIV7)
+					api.Host = c.TargetEs
+					api.Context = requestCtx
+					api.Auth = m.TargetAuth
+					api.HttpProxy = m.Config.TargetProxy
+					m.TargetESAPI = api
+				} else if strings.HasPrefix(descESVersion.Version.Number, "6.") {
+					log.Debug("target es is V6,", descESVersion.Version.Number)
+					api := new(ESAPIV6)
+					api.Host = c.TargetEs
+					api.Context = requestCtx
+					api.Auth = m.TargetAuth
+					api.HttpProxy = m.Config.TargetProxy
+					m.TargetESAPI = api
+				} else if strings.HasPrefix(descESVersion.Version.Number, "5.") {
+					log.Debug("target es is V5,", descESVersion.Version.Number)
+					api := new(ESAPIV5)
+					api.Host = c.TargetEs
+					api.Context = requestCtx
+					api.Auth = m.TargetAuth
+					api.HttpProxy = m.Config.TargetProxy
+					m.TargetESAPI = api
+				} else {
+					log.Debug("target es is not recognized,", descESVersion.Version.Number)
+					log.Warn("target es version is either older than 5 or newer than 8, trying pre V5 apis ....")
+					api := new(ESAPIV0)
+					api.Host = c.TargetEs
+					api.Context = requestCtx
+					api.Auth = m.TargetAuth
+					api.HttpProxy = m.Config.TargetProxy
+					m.TargetESAPI = api
+
+				}
+
+				if c.DryRun {
+					m.TargetESAPI = &DryRunESAPI{ESAPI: m.TargetESAPI, Plan: m.Plan}
+					m.Plan.TargetVersion = descESVersion.Version.Number
+				}
+
+				log.Debug("start process with mappings")
+
+				// wait for cluster state to be okay before moving
+				idleDuration := 3 * time.Second
+				timer := time.NewTimer(idleDuration)
+				defer timer.Stop()
+				for {
+					timer.Reset(idleDuration)
+
+					if len(c.SourceEs) > 0 {
+						if status, ready := m.ClusterReady(m.SourceESAPI); !ready {
+							log.Infof("%s at %s is %s, delaying migration ", status.Name, c.SourceEs, status.Status)
+							select {
+							case <-timer.C:
+							case <-ctx.Done():
+								return ErrInterrupted
+							}
+							continue
+						}
+					}
+
+					if len(c.TargetEs) > 0 {
+						if status, ready := m.ClusterReady(m.TargetESAPI); !ready {
+							log.Infof("%s at %s is %s, delaying migration ", status.Name, c.TargetEs, status.Status)
+							select {
+							case <-timer.C:
+							case <-ctx.Done():
+								return ErrInterrupted
+							}
+							continue
+						}
+					}
+					break
+				}
+
+				// snapshots are restored by the same or the next major only
+				if c.Mode == "snapshot" {
+					if snapshotCompatible(sourceVersion, descESVersion.Version.Number) {
+						err := m.migrateSnapshot(ctx, fetchBar, outputBar)
+						if showBar {
+							fetchBar.Finish()
+							outputBar.Finish()
+							pool.Stop()
+						}
+						return err
+					}
+					if len(c.RenamePattern) > 0 {
+						return fmt.Errorf("snapshots of %s can't be restored on %s, --rename_pattern has no scroll fallback", sourceVersion, descESVersion.Version.Number)
+					}
+					log.Warnf("snapshots of %s can't be restored on %s, falling back to scroll", sourceVersion, descESVersion.Version.Number)
+					if err := planScroll(); err != nil {
+						return err
+					}
+				}
+
+				// the indexes of a dump are created from its header
+				sourceAPI := m.SourceESAPI
+				var header *DumpHeader
+				if len(c.SourceEs) == 0 && len(c.DumpInputFile) > 0 && c.InputFileType == "dump" && (c.CopyIndexSettings || c.CopyIndexMappings || len(c.Alias) > 0) {
+					if header, err = m.readDumpHeader(ctx); err != nil {
+						return err
+					}
+					if header == nil || len(header.Indices) == 0 {
+						if len(c.Alias) > 0 && len(c.TargetIndexName) == 0 {
+							return fmt.Errorf("%s has no header, --alias needs --dest_index", c.DumpInputFile)
+						}
+						log.Warnf("%s has no header, indexes are not created from it", c.DumpInputFile)
+						header = nil
+					} else {
+						log.Infof("creating %d indexes of the dump of %s", len(header.Indices), header.SourceVersion)
+						sourceAPI = &dumpESAPI{header: header}
+						sourceVersion = header.SourceVersion
+					}
+				}
+
+				if sourceAPI != nil {
+					// get all indexes from source
+					indexNames, indexCount, sourceIndexMappings, err := sourceAPI.GetIndexMappings(c.CopyAllIndexes, c.SourceIndexNames)
+
+					if err != nil {
+						return err
+					}
+
+					log.Debugf("indexCount: %d", indexCount)
+
+					if indexCount > 0 {
+						//override indexnames to be copy
+						c.SourceIndexNames = indexNames
+
+						// copy index settings if user asked
+						if c.CopyIndexSettings || c.ShardsCount > 0 || indexSettingsOverride != nil {
+							log.Info("start settings/mappings migration..")
+
+							//get source index settings
+							var sourceIndexSettings *Indexes
+							sourceIndexSettings, err := sourceAPI.GetIndexSettings(c.SourceIndexNames)
+							log.Debug("source index settings:", sourceIndexSettings)
+							if err != nil {
+								return err
+							}
+
+							//get target index settings
+							targetIndexSettings, err := m.TargetESAPI.GetIndexSettings(c.TargetIndexName)
+							if err != nil {
+								//ignore target es settings error
+								log.Debug(err)
+							}
+							log.Debug("target IndexSettings", targetIndexSettings)
+
+							//if there is only one index and we specify the dest indexname
+							if c.SourceIndexNames != c.TargetIndexName && (len(c.TargetIndexName) > 0) && indexCount == 1 {
+								log.Debugf("only one index,so we can rewrite indexname, src:%v, dest:%v ,indexCount:%d", c.SourceIndexNames, c.TargetIndexName, indexCount)
+								(*sourceIndexSettings)[c.TargetIndexName] = (*sourceIndexSettings)[c.SourceIndexNames]
+								delete(*sourceIndexSettings, c.SourceIndexNames)
+								log.Debug(sourceIndexSettings)
+							}
+
+							//delete existing target indexes, after confirmation and backup
+							if c.RecreateIndex && targetIndexSettings != nil {
+								existing := []string{}
+								for name := range *sourceIndexSettings {
+									if _, ok := (*targetIndexSettings)[name]; ok {
+										existing = append(existing, name)
+									}
+								}
+								if err := m.deleteTargetIndices(existing); err != nil {
+									return err
+								}
+							}
+
+							// dealing with indices settings
+							for name, idx := range *sourceIndexSettings {
+								log.Debug("dealing with index,name:", name, ",settings:", idx)
+								tempIndexSettings := getEmptyIndexSettings()
+
+								targetIndexExist := false
+								//if target index settings is exist and we don't copy settings, we use target settings
+								if targetIndexSettings != nil {
+									//if target es have this index and we dont copy index settings
+									if val, ok := (*targetIndexSettings)[name]; ok {
+										targetIndexExist = true
+										tempIndexSettings = val.(map[string]interface{})
+									}
+
+									//deleted above
+									if c.RecreateIndex {
+										targetIndexExist = false
+									}
+								}
+
+								//copy index settings
+								if c.CopyIndexSettings {
+									tempIndexSettings = ((*sourceIndexSettings)[name]).(map[string]interface{})
+								}
+
+								//check map elements
+								if _, ok := tempIndexSettings["settings"]; !ok {
+									tempIndexSettings["settings"] = map[string]interface{}{}
+								}
+
+								if _, ok := tempIndexSettings["settings"].(map[string]interface{})["index"]; !ok {
+									tempIndexSettings["settings"].(map[string]interface{})["index"] = map[string]interface{}{}
+								}
+
+								//override index settings
+								for k, v := range indexSettingsOverride {
+									tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})[k] = v
+								}
+
+								//set refresh_interval, replicas and translog durability, restored after migration
+								restoreSettings := prepareIndexSettings(tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{}))
+
+								//clean up settings
+								delete(tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{}), "number_of_shards")
+
+								//copy indexsettings and mappings
+								if targetIndexExist {
+									log.Debug("update index with settings,", name, tempIndexSettings)
+									//override shard settings
+									if c.ShardsCount > 0 {
+										tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["number_of_shards"] = c.ShardsCount
+									}
+									err := m.TargetESAPI.UpdateIndexSettings(name, tempIndexSettings)
+									if err != nil {
+										log.Error(err)
+									} else {
+										m.Restore.Track(m.TargetESAPI, name, restoreSettings)
+									}
+								} else {
+
+									//override shard settings
+									if c.ShardsCount > 0 {
+										tempIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["number_of_shards"] = c.ShardsCount
+									}
+
+									log.Debug("create index with settings,", name, tempIndexSettings)
+									err := m.TargetESAPI.CreateIndex(name, tempIndexSettings)
+									if err != nil {
+										log.Error(err)
+									} else {
+										m.Restore.Track(m.TargetESAPI, name, restoreSettings)
+									}
+
+								}
+
+							}
+
+							if c.CopyIndexMappings {
+
+								//if there is only one index and we specify the dest indexname
+								if c.SourceIndexNames != c.TargetIndexName && (len(c.TargetIndexName) > 0) && indexCount == 1 {
+									log.Debugf("only one index,so we can rewrite indexname, src:%v, dest:%v ,indexCount:%d", c.SourceIndexNames, c.TargetIndexName, indexCount)
+									(*sourceIndexMappings)[c.TargetIndexName] = (*sourceIndexMappings)[c.SourceIndexNames]
+									delete(*sourceIndexMappings, c.SourceIndexNames)
+									log.Debug(sourceIndexMappings)
+								}
+
+								for name, mapping := range *sourceIndexMappings {
+									// types and field types differ between major versions
+									translated, err := translateMappings(mapping.(map[string]interface{})["mappings"].(map[string]interface{}), sourceVersion, descESVersion.Version.Number, c.OverrideTypeName)
+									if err != nil {
+										log.Errorf("mappings of %s: %v", name, err)
+										continue
+									}
+									err = m.TargetESAPI.UpdateIndexMapping(name, translated)
+									if err != nil {
+										log.Error(err)
+									}
+								}
+							}
+
+							if header != nil && c.CopyIndexSettings {
+								if err := m.addDumpAliases(header); err != nil {
+									log.Error(err)
+								}
+							}
+
+							log.Info("settings/mappings migration finished.")
+						}
+
+					} else {
+						return fmt.Errorf("index not exists, %s", c.SourceIndexNames)
+					}
+				}
+
+				// target pulls the documents, nothing is read or written by esm
+				if c.Mode == "reindex_remote" {
+					err := m.reindexRemote(ctx, fetchBar, outputBar)
+					if showBar {
+						fetchBar.Finish()
+						outputBar.Finish()
+						pool.Stop()
+					}
+					return err
+				}
+			}
+
+			if c.DryRun {
+				log.Info("dry run, building migration plan")
+				return m.buildPlan(lineCount)
+			}
+
+			if c.SkipDocuments {
+				log.Info("documents are not migrated")
+				return nil
+			}
+
+			log.Info("start data migration..")
+
+			var monitorDone chan struct{}
+
+			// a failed writer stops the readers
+			readCtx, stopReading := context.WithCancel(ctx)
+			defer stopReading()
+			readers := errgroup.Group{}
+			writers := errgroup.Group{}
+			if readInput != nil {
+				readers.Go(func() error {
+					return readInput(readCtx)
+				})
+			} else {
+				m.Docs.Close()
+			}
+
+			//start es bulk thread
+			if len(c.TargetEs) > 0 {
+				log.Debug("start es bulk workers")
+				if m.Progress == nil {
+					outputBar.Prefix("Bulk")
+				}
+				m.Throttle = NewThroughputController(c)
+				m.DocLimiter = NewRateLimiter(c.MaxDocsPerSec)
+				m.ByteLimiter = NewRateLimiter(c.MaxBytesPerSec)
+				if c.Adaptive && c.QueueThreshold > 0 {
+					monitorDone = make(chan struct{})
+					go m.MonitorQueue(monitorDone)
+				}
+				for i := 0; i < c.Workers; i++ {
+					writers.Go(func() error {
+						if err := m.NewBulkWorker(ctx, outputBar); err != nil {
+							// release the readers waiting on the queue
+							stopReading()
+							m.Docs.Drain()
+							return err
+						}
+						return nil
+					})
+				}
+			} else if len(c.DumpOutFile) > 0 {
+				// start file write
+				if m.Progress == nil {
+					outputBar.Prefix("Write")
+				}
+				writers.Go(func() error {
+					if err := m.NewFileDumpWorker(requestCtx, outputBar); err != nil {
+						// release the readers waiting on the queue
+						stopReading()
+						m.Docs.Drain()
+						return err
+					}
+					return nil
+				})
+			} else if len(c.OutputTopic) > 0 {
+				if m.Progress == nil {
+					outputBar.Prefix("Produce")
+				}
+				writers.Go(func() error {
+					if err := m.NewKafkaWorker(requestCtx, outputBar); err != nil {
+						// release the readers waiting on the queue
+						stopReading()
+						m.Docs.Drain()
+						return err
+					}
+					return nil
+				})
+			}
+
+			readErr := readers.Wait()
+			writeErr := writers.Wait()
+			m.Metrics.UntrackQueue(m.Docs)
+
+			if monitorDone != nil {
+				close(monitorDone)
+			}
+
+			if showBar {
+
+				outputBar.Finish()
+				// close pool
+				pool.Stop()
+
+			}
+
+			if ctx.Err() != nil {
+				return ErrInterrupted
+			}
+			if err := errors.Join(readErr, writeErr); err != nil {
+				return err
+			}
+		}
+
+	}
+
+	log.Info("data migration finished.")
+	return nil
+}
+
+func (c *Migrator) recoveryIndexSettings() error {
+	//update replica, refresh_interval and translog durability
+	return c.Restore.Restore(c.Config.Refresh, c.Config.GreenTimeout)
+}
+
+// newSourceESAPI returns the api of the source version, 8.x is read with the
+// 7.x api
+func newSourceESAPI(ctx context.Context, version string, c *Config, auth *Auth) ESAPI {
+	if strings.HasPrefix(version, "9.") || strings.HasPrefix(version, "8.") {
+		log.Debug("source es is V8,", version)
+		api := new(ESAPIV7)
+		api.Host = c.SourceEs
+		api.Context = ctx
+		api.Compress = c.Compress
+		api.Auth = auth
+		api.HttpProxy = c.SourceProxy
+		return api
+	} else if strings.HasPrefix(version, "7.") {
+		log.Debug("source es is V7,", version)
+		api := new(ESAPIV7)
+		api.Host = c.SourceEs
+		api.Context = ctx
+		api.Compress = c.Compress
+		api.Auth = auth
+		api.HttpProxy = c.SourceProxy
+		return api
+	} else if strings.HasPrefix(version, "6.") {
+		log.Debug("source es is V6,", version)
+		api := new(ESAPIV6)
+		api.Compress = c.Compress
+		api.Host = c.SourceEs
+		api.Context = ctx
+		api.Auth = auth
+		api.HttpProxy = c.SourceProxy
+		return api
+	} else if strings.HasPrefix(version, "5.") {
+		log.Debug("source es is V5,", version)
+		api := new(ESAPIV5)
+		api.Host = c.SourceEs
+		api.Context = ctx
+		api.Compress = c.Compress
+		api.Auth = auth
+		api.HttpProxy = c.SourceProxy
+		return api
+	}
+	log.Debug("source es is not V5,", version)
+	api := new(ESAPIV0)
+	api.Host = c.SourceEs
+	api.Context = ctx
+	api.Compress = c.Compress
+	api.Auth = auth
+	api.HttpProxy = c.SourceProxy
+	return api
+}
+
+func (c *Migrator) ClusterVersion(host string, auth *Auth, proxy string) (*ClusterVersion, []error) {
+
+	url := fmt.Sprint(host)
+	resp, body, errs := Get(url, auth, proxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		log.Error(errs)
+		return nil, errs
+	}
+
+	log.Debug(body)
+
+	version := &ClusterVersion{}
+	err := json.Unmarshal([]byte(body), version)
+
+	if err != nil {
+		log.Error(body, err)
+		return nil, []error{err}
+	}
+	return version, nil
+}
+
+func (c *Migrator) ClusterReady(api ESAPI) (*ClusterHealth, bool) {
+	health := api.ClusterHealth()
+
+	if !c.Config.WaitForGreen {
+		return health, true
+	}
+
+	if health.Status == "red" {
+		return health, false
+	}
+
+	if !c.Config.WaitForGreen && health.Status == "yellow" {
+		return health, true
+	}
+
+	if health.Status == "green" {
+		return health, true
+	}
+
+	return health, false
+}
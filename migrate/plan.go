@@ -0,0 +1,381 @@
+package migrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/raminhz90/esm/util"
+)
+
+// Plan describes what a migration would do, built by --dry_run
+type Plan struct {
+	lock sync.Mutex
+
+	Source        string        `json:"source,omitempty"`
+	SourceVersion string        `json:"source_version,omitempty"`
+	InputFile     string        `json:"input_file,omitempty"`
+	Target        string        `json:"target,omitempty"`
+	TargetVersion string        `json:"target_version,omitempty"`
+	OutputFile    string        `json:"output_file,omitempty"`
+	Query         string        `json:"query,omitempty"`
+	Indices       []*PlanIndex  `json:"indices"`
+	Actions       []*PlanAction `json:"actions"`
+	TotalDocs     int64         `json:"total_docs"`
+	TotalBytes    int64         `json:"total_bytes"`
+}
+
+type PlanIndex struct {
+	Source         string `json:"source"`
+	Target         string `json:"target,omitempty"`
+	TargetExists   bool   `json:"target_exists"`
+	Action         string `json:"action"`
+	Docs           int64  `json:"docs"`
+	ExpectedDocs   int64  `json:"expected_docs"`
+	StoreBytes     int64  `json:"store_bytes"`
+	EstimatedBytes int64  `json:"estimated_bytes"`
+}
+
+// PlanAction is a write request to target that was not sent
+type PlanAction struct {
+	Action string      `json:"action"`
+	Index  string      `json:"index"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+func (p *Plan) record(action, index string, body interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.Actions = append(p.Actions, &PlanAction{Action: action, Index: index, Body: copyJson(body)})
+}
+
+// copyJson takes a snapshot of settings and mappings, the apis modify the
+// maps they are given
+func copyJson(in interface{}) interface{} {
+	if in == nil {
+		return nil
+	}
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	DecodeJsonBytes(data, &out)
+	return out
+}
+
+// actionOf returns the first recorded action on the index that changes it
+func (p *Plan) actionOf(index string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	deleted := false
+	for _, action := range p.Actions {
+		if action.Index != index {
+			continue
+		}
+		switch action.Action {
+		case "delete":
+			deleted = true
+		case "create":
+			if deleted {
+				return "recreate"
+			}
+			return "create"
+		case "update_settings", "update_mapping":
+			return "update"
+		}
+	}
+	if deleted {
+		return "delete"
+	}
+	return "write"
+}
+
+// DryRunESAPI passes read requests to the target and records the writes
+// into the plan instead of sending them
+type DryRunESAPI struct {
+	ESAPI
+	Plan *Plan
+}
+
+func (s *DryRunESAPI) Bulk(data *bytes.Buffer) (*BulkResponse, error) {
+	data.Reset()
+	return &BulkResponse{}, nil
+}
+
+func (s *DryRunESAPI) DeleteIndex(name string) error {
+	s.Plan.record("delete", name, nil)
+	return nil
+}
+
+func (s *DryRunESAPI) Snapshot(repository string, snapshot string, indexNames string) error {
+	s.Plan.record("snapshot", indexNames, map[string]interface{}{"repository": repository, "snapshot": snapshot})
+	return nil
+}
+
+func (s *DryRunESAPI) CreateRepository(name string, settings map[string]interface{}) error {
+	s.Plan.record("create_repository", name, settings)
+	return nil
+}
+
+func (s *DryRunESAPI) StartSnapshot(repository string, snapshot string, indexNames string) error {
+	return s.Snapshot(repository, snapshot, indexNames)
+}
+
+func (s *DryRunESAPI) RestoreSnapshot(repository string, snapshot string, indexNames string, renamePattern string, renameReplacement string) error {
+	body := map[string]interface{}{"repository": repository, "snapshot": snapshot}
+	if len(renamePattern) > 0 {
+		body["rename_pattern"] = renamePattern
+		body["rename_replacement"] = renameReplacement
+	}
+	s.Plan.record("restore", indexNames, body)
+	return nil
+}
+
+func (s *DryRunESAPI) Reindex(source string, dest string) error {
+	s.Plan.record("reindex", source, map[string]interface{}{"dest": dest})
+	return nil
+}
+
+func (s *DryRunESAPI) StartReindex(request map[string]interface{}) (string, error) {
+	body := copyJson(request)
+	dest, _ := request["dest"].(map[string]interface{})
+	index, _ := dest["index"].(string)
+	// the password of source is not shown
+	if source, ok := body.(map[string]interface{})["source"].(map[string]interface{}); ok {
+		if remote, ok := source["remote"].(map[string]interface{}); ok && remote["password"] != nil {
+			remote["password"] = "******"
+		}
+	}
+	s.Plan.record("reindex_remote", index, body)
+	return "", nil
+}
+
+func (s *DryRunESAPI) CloseIndex(name string) error {
+	s.Plan.record("close", name, nil)
+	return nil
+}
+
+func (s *DryRunESAPI) UpdateAliases(actions []map[string]interface{}) error {
+	alias := ""
+	for _, action := range actions {
+		for _, target := range action {
+			alias, _ = target.(map[string]interface{})["alias"].(string)
+		}
+	}
+	s.Plan.record("aliases", alias, map[string]interface{}{"actions": actions})
+	return nil
+}
+
+func (s *DryRunESAPI) CreateIndex(name string, settings map[string]interface{}) error {
+	cleanSettings(settings)
+	s.Plan.record("create", name, settings)
+	return nil
+}
+
+func (s *DryRunESAPI) UpdateIndexSettings(indexName string, settings map[string]interface{}) error {
+	cleanSettings(settings)
+	s.Plan.record("update_settings", indexName, settings)
+	return nil
+}
+
+func (s *DryRunESAPI) UpdateIndexMapping(indexName string, mappings map[string]interface{}) error {
+	s.Plan.record("update_mapping", indexName, mappings)
+	return nil
+}
+
+func (s *DryRunESAPI) Refresh(name string) error {
+	return nil
+}
+
+func (s *DryRunESAPI) WaitForStatus(indexNames string, status string, timeout time.Duration) error {
+	return nil
+}
+
+// buildPlan resolves the indexes of the source and estimates the documents
+// and bytes to be moved
+func (m *Migrator) buildPlan(lineCount int) error {
+	c := m.Config
+	p := m.Plan
+	p.Source = c.SourceEs
+	p.InputFile = c.DumpInputFile
+	p.Target = c.TargetEs
+	p.OutputFile = c.DumpOutFile
+	p.Query = c.Query
+	if p.Actions == nil {
+		p.Actions = []*PlanAction{}
+	}
+
+	if len(c.SourceEs) == 0 {
+		p.Indices = append(p.Indices, &PlanIndex{Source: c.DumpInputFile, Target: c.TargetIndexName, Action: "write", Docs: int64(lineCount), ExpectedDocs: int64(lineCount)})
+		p.TotalDocs = int64(lineCount)
+		return nil
+	}
+
+	indexNames, indexCount, _, err := m.SourceESAPI.GetIndexMappings(c.CopyAllIndexes, c.SourceIndexNames)
+	if err != nil {
+		return err
+	}
+	if indexCount == 0 {
+		return fmt.Errorf("index not exists, %s", c.SourceIndexNames)
+	}
+
+	stats, err := m.SourceESAPI.GetIndexStats(indexNames)
+	if err != nil {
+		return err
+	}
+
+	var targetIndexSettings *Indexes
+	if m.TargetESAPI != nil {
+		targetIndexSettings, _ = m.TargetESAPI.GetIndexSettings("_all")
+	}
+
+	names := strings.Split(indexNames, ",")
+	sort.Strings(names)
+	for _, name := range names {
+		idx := &PlanIndex{Source: name}
+		if s, ok := stats[name]; ok {
+			idx.Docs = s.Docs
+			idx.StoreBytes = s.StoreBytes
+		}
+		idx.ExpectedDocs = idx.Docs
+		idx.EstimatedBytes = idx.StoreBytes
+		if len(c.Query) > 0 && idx.Docs > 0 {
+			count, err := m.SourceESAPI.Count(name, c.Query)
+			if err != nil {
+				return err
+			}
+			idx.ExpectedDocs = int64(count)
+			idx.EstimatedBytes = idx.StoreBytes * idx.ExpectedDocs / idx.Docs
+		}
+
+		if m.TargetESAPI != nil {
+			idx.Target = name
+			if len(c.TargetIndexName) > 0 {
+				idx.Target = c.TargetIndexName
+			}
+			if targetIndexSettings != nil {
+				_, idx.TargetExists = (*targetIndexSettings)[idx.Target]
+			}
+			idx.Action = p.actionOf(idx.Target)
+			if c.Mode == "snapshot" {
+				idx.Target = m.restoredName(name)
+				idx.Action = "restore"
+			} else if c.Mode == "reindex_remote" {
+				idx.Action = "reindex_remote"
+			}
+		} else {
+			idx.Action = "dump"
+		}
+
+		p.Indices = append(p.Indices, idx)
+		p.TotalDocs += idx.ExpectedDocs
+		p.TotalBytes += idx.EstimatedBytes
+	}
+	return nil
+}
+
+// Print writes the plan in text or json format
+func (p *Plan) Print(w io.Writer, format string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(p)
+		return
+	}
+
+	if len(p.Source) > 0 {
+		fmt.Fprintf(w, "source: %s (%s)\n", p.Source, p.SourceVersion)
+	} else {
+		fmt.Fprintf(w, "input file: %s\n", p.InputFile)
+	}
+	if len(p.Target) > 0 {
+		fmt.Fprintf(w, "target: %s (%s)\n", p.Target, p.TargetVersion)
+	} else {
+		fmt.Fprintf(w, "output file: %s\n", p.OutputFile)
+	}
+	if len(p.Query) > 0 {
+		fmt.Fprintf(w, "query: %s\n", p.Query)
+	}
+
+	fmt.Fprintln(w, "\nindexes:")
+	for _, idx := range p.Indices {
+		target := idx.Target
+		if idx.TargetExists {
+			target += " (exists)"
+		}
+		fmt.Fprintf(w, "  %s => %s, %s, docs: %d of %d, size: ~%s\n", idx.Source, target, idx.Action, idx.ExpectedDocs, idx.Docs, formatBytes(idx.EstimatedBytes))
+	}
+	fmt.Fprintf(w, "  total docs: %d, size: ~%s\n", p.TotalDocs, formatBytes(p.TotalBytes))
+
+	if len(p.Actions) > 0 {
+		fmt.Fprintln(w, "\nactions on target:")
+		for i, action := range p.Actions {
+			fmt.Fprintf(w, "  %d. %s %s\n", i+1, action.Action, action.Index)
+			if action.Body != nil {
+				fmt.Fprintf(w, "     %s\n", util.ToJson(action.Body, false))
+			}
+		}
+	}
+}
+
+// PrintPlans writes the plans of all jobs, jobs that failed before
+// planning have no plan
+func PrintPlans(w io.Writer, plans []*Plan, format string) {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if len(plans) == 1 {
+			enc.Encode(plans[0])
+		} else {
+			enc.Encode(plans)
+		}
+		return
+	}
+
+	for i, p := range plans {
+		if len(plans) > 1 {
+			fmt.Fprintf(w, "\n# job %d\n", i+1)
+		}
+		if p == nil {
+			fmt.Fprintln(w, "no plan, the job failed")
+			continue
+		}
+		p.Print(w, format)
+	}
+}
+
+// WritePlans saves the plans in text or json format
+func WritePlans(path string, plans []*Plan, format string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	PrintPlans(f, plans, format)
+	log.Infof("plan saved to %s", path)
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,52 @@
+package migrate
+
+import (
+	"os"
+	"sync/atomic"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+)
+
+// Progress is the overall progress view shared by the jobs of a batch
+type Progress struct {
+	FetchBar  *pb.ProgressBar
+	OutputBar *pb.ProgressBar
+	pool      *pb.Pool
+}
+
+// StartProgress draws the bars on the console if it is a terminal
+func StartProgress(console *os.File) *Progress {
+	p := &Progress{
+		FetchBar:  pb.New(0).Prefix("Read  "),
+		OutputBar: pb.New(0).Prefix("Output"),
+	}
+	if IsTerminal(console) {
+		pool := pb.NewPool(p.FetchBar, p.OutputBar)
+		pool.Output = console
+		if err := pool.Start(); err != nil {
+			log.Error(err)
+		} else {
+			p.pool = pool
+		}
+	}
+	return p
+}
+
+// AddTotal grows the totals once a job knows how many documents it moves
+func (p *Progress) AddTotal(n int) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddInt64(&p.FetchBar.Total, int64(n))
+	atomic.AddInt64(&p.OutputBar.Total, int64(n))
+}
+
+func (p *Progress) Stop() {
+	if p == nil || p.pool == nil {
+		return
+	}
+	p.FetchBar.Finish()
+	p.OutputBar.Finish()
+	p.pool.Stop()
+}
@@ -0,0 +1,85 @@
+package migrate
+
+import (
+	"sync"
+)
+
+// DocQueue buffers the documents between readers and writers. It is bounded
+// by the bytes of the raw _source as well as by the number of documents,
+// readers block in Put while it is full.
+type DocQueue struct {
+	docs chan *Hit
+
+	lock  sync.Mutex
+	cond  *sync.Cond
+	bytes int64
+	limit int64
+}
+
+func NewDocQueue(count int, limitBytes int64) *DocQueue {
+	if count < 1 {
+		count = 1
+	}
+	q := &DocQueue{docs: make(chan *Hit, count), limit: limitBytes}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// Put adds the document, waiting for writers to free enough bytes. A document
+// larger than the limit passes alone.
+func (q *DocQueue) Put(hit *Hit) {
+	size := int64(len(hit.Source))
+	hit.size = size
+	q.lock.Lock()
+	for q.limit > 0 && q.bytes > 0 && q.bytes+size > q.limit {
+		q.cond.Wait()
+	}
+	q.bytes += size
+	q.lock.Unlock()
+	q.docs <- hit
+}
+
+// C is read by writers, every document taken from it must be passed to Done
+func (q *DocQueue) C() <-chan *Hit {
+	return q.docs
+}
+
+// Done frees the bytes of a document taken by a writer
+func (q *DocQueue) Done(hit *Hit) {
+	q.lock.Lock()
+	q.bytes -= hit.size
+	q.lock.Unlock()
+	q.cond.Broadcast()
+}
+
+// Drain discards the documents until the queue is closed, so readers don't
+// wait forever when the writers failed
+func (q *DocQueue) Drain() {
+	for hit := range q.docs {
+		q.Done(hit)
+		hit.ack(false)
+	}
+}
+
+// Close is called once all readers are finished
+func (q *DocQueue) Close() {
+	close(q.docs)
+}
+
+func (q *DocQueue) Len() int {
+	return len(q.docs)
+}
+
+func (q *DocQueue) Cap() int {
+	return cap(q.docs)
+}
+
+func (q *DocQueue) Bytes() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.bytes
+}
+
+func (q *DocQueue) Limit() int64 {
+	return q.limit
+}
@@ -0,0 +1,259 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+)
+
+// ReadTask is one scroll over a part of the source, a slice of the indexes,
+// a shard of an index or a range of the split field
+type ReadTask struct {
+	Name       string
+	Indices    string
+	Query      string
+	Preference string
+	Slice      int
+	MaxSlices  int
+}
+
+func (t ReadTask) String() string {
+	if t.MaxSlices > 1 {
+		return fmt.Sprintf("%s slice %d", t.Name, t.Slice)
+	}
+	return t.Name
+}
+
+// sliced turns the task into one task per slice of the sliced scroll
+func (t ReadTask) sliced(slices int) []ReadTask {
+	if slices < 2 {
+		t.MaxSlices = 1
+		return []ReadTask{t}
+	}
+	tasks := []ReadTask{}
+	for slice := 0; slice < slices; slice++ {
+		t.Slice = slice
+		t.MaxSlices = slices
+		tasks = append(tasks, t)
+	}
+	return tasks
+}
+
+// andQuery combines the query of the job with the query of a split
+func andQuery(query string, split string) string {
+	if len(query) == 0 {
+		return split
+	}
+	return fmt.Sprintf("(%s) AND %s", query, split)
+}
+
+// planReads splits reading of the source by --split, every task is read by
+// its own scroll
+func (m *Migrator) planReads() ([]ReadTask, error) {
+	c := m.Config
+	tasks := []ReadTask{}
+
+	switch c.Split {
+	case "index", "shard":
+		settings, err := m.SourceESAPI.GetIndexSettings(c.SourceIndexNames)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(*settings))
+		for name := range *settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if c.Split == "index" {
+				tasks = append(tasks, ReadTask{Name: "index " + name, Indices: name, Query: c.Query}.sliced(c.ScrollSliceSize)...)
+				continue
+			}
+			shards := numberOfShards((*settings)[name])
+			if shards < 1 {
+				return nil, fmt.Errorf("unknown number of shards of index %s", name)
+			}
+			for shard := 0; shard < shards; shard++ {
+				tasks = append(tasks, ReadTask{
+					Name:       fmt.Sprintf("index %s shard %d", name, shard),
+					Indices:    name,
+					Query:      c.Query,
+					Preference: fmt.Sprintf("_shards:%d", shard),
+					MaxSlices:  1,
+				})
+			}
+		}
+
+	case "range":
+		stats, err := m.SourceESAPI.FieldStats(c.SourceIndexNames, c.SplitField, c.Query)
+		if err != nil {
+			return nil, err
+		}
+		if stats.Count == 0 {
+			log.Warnf("no values of %s on source, reading without split", c.SplitField)
+			return ReadTask{Name: "scroll", Indices: c.SourceIndexNames, Query: c.Query}.sliced(c.ScrollSliceSize), nil
+		}
+		for _, r := range splitRanges(stats.Min, stats.Max, c.SplitCount) {
+			split := fmt.Sprintf("%s:%s", c.SplitField, r)
+			tasks = append(tasks, ReadTask{Name: split, Indices: c.SourceIndexNames, Query: andQuery(c.Query, split)}.sliced(c.ScrollSliceSize)...)
+		}
+		// documents without the field are in no range
+		missing := "NOT _exists_:" + c.SplitField
+		tasks = append(tasks, ReadTask{Name: missing, Indices: c.SourceIndexNames, Query: andQuery(c.Query, missing)}.sliced(c.ScrollSliceSize)...)
+
+	default:
+		tasks = ReadTask{Name: "scroll", Indices: c.SourceIndexNames, Query: c.Query}.sliced(c.ScrollSliceSize)
+	}
+
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("index not exists, %s", c.SourceIndexNames)
+	}
+	return tasks, nil
+}
+
+// numberOfShards reads the shard count from the settings of an index, both
+// nested and flat settings are understood
+func numberOfShards(index interface{}) int {
+	idx, _ := index.(map[string]interface{})
+	settings, _ := idx["settings"].(map[string]interface{})
+	value := settings["index.number_of_shards"]
+	if nested, ok := settings["index"].(map[string]interface{}); ok && value == nil {
+		value = nested["number_of_shards"]
+	}
+	switch v := value.(type) {
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	case float64:
+		return int(v)
+	}
+	return 0
+}
+
+// splitRanges cuts [min, max] into count ranges in query string syntax, the
+// upper bound is exclusive but for the last range. Whole bounds, as of long
+// and date fields, are kept whole.
+func splitRanges(min, max float64, count int) []string {
+	if count < 1 {
+		count = 1
+	}
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	step := (max - min) / float64(count)
+	if min == math.Trunc(min) && max == math.Trunc(max) {
+		step = math.Ceil((max - min + 1) / float64(count))
+	}
+	if step <= 0 {
+		return []string{fmt.Sprintf("[%s TO %s]", format(min), format(max))}
+	}
+
+	ranges := []string{}
+	for i := 0; i < count; i++ {
+		lo := min + float64(i)*step
+		hi := lo + step
+		if hi >= max || i == count-1 {
+			ranges = append(ranges, fmt.Sprintf("[%s TO %s]", format(lo), format(max)))
+			break
+		}
+		ranges = append(ranges, fmt.Sprintf("[%s TO %s}", format(lo), format(hi)))
+	}
+	return ranges
+}
+
+// readTasks scrolls the tasks with a pool of --readers, the errors of failed
+// tasks are returned, the other tasks go on
+func (m *Migrator) readTasks(ctx context.Context, tasks []ReadTask, bar *pb.ProgressBar) []error {
+	readers := m.Config.Readers
+	if readers < 1 {
+		readers = m.Config.ScrollSliceSize
+	}
+	if readers > len(tasks) {
+		readers = len(tasks)
+	}
+	log.Debugf("reading %d tasks with %d readers", len(tasks), readers)
+
+	var errs []error
+	errsLock := sync.Mutex{}
+	taskChan := make(chan ReadTask)
+	wg := sync.WaitGroup{}
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChan {
+				if err := m.readTask(ctx, task, bar); err != nil {
+					m.reportError(fmt.Errorf("%s failed, %v", task, err))
+					errsLock.Lock()
+					errs = append(errs, fmt.Errorf("%s: %v", task, err))
+					errsLock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		taskChan <- task
+	}
+	close(taskChan)
+	wg.Wait()
+	return errs
+}
+
+// readTask opens the scroll of the task and reads it until done, failed or
+// stopped
+func (m *Migrator) readTask(ctx context.Context, task ReadTask, bar *pb.ProgressBar) error {
+	c := m.Config
+	log.Debug("start reading ", task)
+
+	scroll, err := m.SourceESAPI.NewScroll(task.Indices, c.ScrollTime, c.DocBufferCount, task.Query, task.Slice, task.MaxSlices, c.Fields, task.Preference)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	temp, ok := scroll.(ScrollAPI)
+	if !ok {
+		return errors.New("invalid scroll response")
+	}
+	if err := temp.Err(); err != nil {
+		return err
+	}
+
+	// free the search context on source
+	defer func() {
+		if err := m.SourceESAPI.ClearScroll(temp.GetScrollId()); err != nil {
+			log.Debug("failed to clear scroll, ", err)
+		}
+	}()
+
+	temp.ProcessScrollResult(m, bar)
+	for ctx.Err() == nil {
+		done, err := temp.Next(ctx, m, bar)
+		if err != nil {
+			if ctx.Err() != nil {
+				// stopped while waiting for the next page
+				break
+			}
+			return err
+		}
+		if done {
+			break
+		}
+	}
+	log.Debug("finished reading ", task)
+	return nil
+}
@@ -0,0 +1,52 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max float64
+		count    int
+		expected []string
+	}{
+		{"even", 0, 99, 4, []string{"[0 TO 25}", "[25 TO 50}", "[50 TO 75}", "[75 TO 99]"}},
+		{"uneven", 0, 10, 3, []string{"[0 TO 4}", "[4 TO 8}", "[8 TO 10]"}},
+		{"fewer ranges than asked", 0, 9, 4, []string{"[0 TO 3}", "[3 TO 6}", "[6 TO 9]"}},
+		{"epoch ms dates", 1577836800000, 1577923199999, 4, []string{
+			"[1577836800000 TO 1577858400000}", "[1577858400000 TO 1577880000000}",
+			"[1577880000000 TO 1577901600000}", "[1577901600000 TO 1577923199999]"}},
+		{"fractions", 0.5, 2.5, 2, []string{"[0.5 TO 1.5}", "[1.5 TO 2.5]"}},
+		{"single value", 5, 5, 4, []string{"[5 TO 5]"}},
+		{"single fraction", 1.5, 1.5, 4, []string{"[1.5 TO 1.5]"}},
+		{"no count", 0, 9, 0, []string{"[0 TO 9]"}},
+	}
+	for _, test := range tests {
+		if ranges := splitRanges(test.min, test.max, test.count); !reflect.DeepEqual(ranges, test.expected) {
+			t.Errorf("%s: ranges %q, expected %q", test.name, ranges, test.expected)
+		}
+	}
+}
+
+func TestNumberOfShards(t *testing.T) {
+	tests := []struct {
+		name     string
+		index    interface{}
+		expected int
+	}{
+		{"nested", map[string]interface{}{"settings": map[string]interface{}{"index": map[string]interface{}{"number_of_shards": "5"}}}, 5},
+		{"flat", map[string]interface{}{"settings": map[string]interface{}{"index.number_of_shards": "3"}}, 3},
+		{"single shard", map[string]interface{}{"settings": map[string]interface{}{"index": map[string]interface{}{"number_of_shards": float64(1)}}}, 1},
+		{"flat before nested", map[string]interface{}{"settings": map[string]interface{}{
+			"index.number_of_shards": "2", "index": map[string]interface{}{"number_of_shards": "7"}}}, 2},
+		{"no shards", map[string]interface{}{"settings": map[string]interface{}{"index": map[string]interface{}{}}}, 0},
+		{"no settings", nil, 0},
+	}
+	for _, test := range tests {
+		if shards := numberOfShards(test.index); shards != test.expected {
+			t.Errorf("%s: %d shards, expected %d", test.name, shards, test.expected)
+		}
+	}
+}
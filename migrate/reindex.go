@@ -0,0 +1,219 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+	"github.com/raminhz90/esm/util"
+)
+
+// planReindex returns one task per source index, or per index and range of
+// --split_field with --split=range. Reindex from remote can't be sliced.
+func (m *Migrator) planReindex() ([]ReadTask, error) {
+	c := m.Config
+	names := strings.Split(c.SourceIndexNames, ",")
+	sort.Strings(names)
+
+	splits := []string{}
+	if c.Split == "range" {
+		stats, err := m.SourceESAPI.FieldStats(c.SourceIndexNames, c.SplitField, c.Query)
+		if err != nil {
+			return nil, err
+		}
+		if stats.Count > 0 {
+			for _, r := range splitRanges(stats.Min, stats.Max, c.SplitCount) {
+				splits = append(splits, fmt.Sprintf("%s:%s", c.SplitField, r))
+			}
+			// documents without the field are in no range
+			splits = append(splits, "NOT _exists_:"+c.SplitField)
+		} else {
+			log.Warnf("no values of %s on source, reindexing without split", c.SplitField)
+		}
+	}
+
+	tasks := []ReadTask{}
+	for _, name := range names {
+		if len(splits) == 0 {
+			tasks = append(tasks, ReadTask{Name: "index " + name, Indices: name, Query: c.Query, MaxSlices: 1})
+			continue
+		}
+		for _, split := range splits {
+			tasks = append(tasks, ReadTask{Name: fmt.Sprintf("index %s %s", name, split), Indices: name, Query: andQuery(c.Query, split), MaxSlices: 1})
+		}
+	}
+	return tasks, nil
+}
+
+// reindexRequest is the _reindex of the task pulling from source
+func (m *Migrator) reindexRequest(task ReadTask) map[string]interface{} {
+	c := m.Config
+
+	remote := map[string]interface{}{"host": c.SourceEs}
+	if len(c.RemoteHost) > 0 {
+		remote["host"] = c.RemoteHost
+	}
+	if m.SourceAuth != nil {
+		remote["username"] = m.SourceAuth.User
+		remote["password"] = m.SourceAuth.Pass
+	}
+
+	source := map[string]interface{}{
+		"remote": remote,
+		"index":  task.Indices,
+		"size":   c.DocBufferCount,
+	}
+	if len(task.Query) > 0 {
+		source["query"] = map[string]interface{}{
+			"query_string": map[string]interface{}{"query": task.Query},
+		}
+	}
+	if len(c.Fields) > 0 {
+		source["_source"] = strings.Split(c.Fields, ",")
+	}
+
+	dest := task.Indices
+	if len(c.TargetIndexName) > 0 {
+		dest = c.TargetIndexName
+	}
+	return map[string]interface{}{
+		"source": source,
+		"dest":   map[string]interface{}{"index": dest},
+	}
+}
+
+// reindexRemote makes target pull the documents of source with _reindex
+// tasks, --readers of them run at a time. Failed documents are counted and
+// failed tasks returned, the other tasks go on.
+func (m *Migrator) reindexRemote(ctx context.Context, fetchBar *pb.ProgressBar, outputBar *pb.ProgressBar) error {
+	c := m.Config
+
+	tasks, err := m.planReindex()
+	if err != nil {
+		return err
+	}
+
+	if c.DryRun {
+		for _, task := range tasks {
+			m.TargetESAPI.StartReindex(m.reindexRequest(task))
+		}
+		return m.buildPlan(0)
+	}
+
+	if m.Progress == nil {
+		fetchBar.Prefix("Remote")
+		outputBar.Prefix("Reindex")
+	}
+
+	readers := c.Readers
+	if readers < 1 {
+		readers = 1
+	}
+	if readers > len(tasks) {
+		readers = len(tasks)
+	}
+	log.Infof("reindexing %d tasks from remote, %d at a time", len(tasks), readers)
+
+	var errs []error
+	errsLock := sync.Mutex{}
+	taskChan := make(chan ReadTask)
+	wg := sync.WaitGroup{}
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChan {
+				if err := m.reindexTask(ctx, task, fetchBar, outputBar); err != nil {
+					m.reportError(fmt.Errorf("%s failed, %v", task, err))
+					errsLock.Lock()
+					errs = append(errs, fmt.Errorf("%s: %v", task, err))
+					errsLock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, task := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		taskChan <- task
+	}
+	close(taskChan)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ErrInterrupted
+	}
+	return errors.Join(errs...)
+}
+
+// reindexTask runs one _reindex task and polls it until it is completed,
+// the task is cancelled when the migration stops
+func (m *Migrator) reindexTask(ctx context.Context, task ReadTask, fetchBar *pb.ProgressBar, outputBar *pb.ProgressBar) error {
+	request := m.reindexRequest(task)
+	dest := request["dest"].(map[string]interface{})["index"].(string)
+	if dest != task.Indices {
+		m.Metrics.MapIndex(dest, task.Indices)
+	}
+
+	taskId, err := m.TargetESAPI.StartReindex(request)
+	if err != nil {
+		return err
+	}
+	log.Debugf("started %s as task %s", task, taskId)
+	// the duration of the index starts with its first task
+	m.Metrics.AddScrolled(task.Indices, 0)
+
+	done := 0
+	for {
+		if err := sleepContext(ctx, time.Second); err != nil {
+			if err := m.TargetESAPI.CancelTask(taskId); err != nil {
+				log.Warn(err)
+			} else {
+				log.Warnf("cancelled task %s of %s", taskId, task)
+			}
+			return nil
+		}
+
+		status, err := m.TargetESAPI.TaskStatus(taskId)
+		if err != nil {
+			return err
+		}
+
+		progress := status.Task.Status
+		if status.Completed {
+			progress = status.Response.ReindexProgress
+		}
+		written := progress.Created + progress.Updated + progress.Deleted
+		if written > done {
+			fetchBar.Add(written - done)
+			outputBar.Add(written - done)
+			done = written
+		}
+
+		if !status.Completed {
+			continue
+		}
+
+		if status.Error != nil {
+			return fmt.Errorf("task %s failed: %s", taskId, util.ToJson(status.Error, false))
+		}
+		m.Metrics.AddScrolled(task.Indices, progress.Total)
+		m.Metrics.AddBulked(dest, written)
+		for _, failure := range status.Response.Failures {
+			m.reportError(fmt.Errorf("failed to reindex document of %s: %s", task, util.ToJson(failure, false)))
+		}
+		if len(status.Response.Failures) > 0 {
+			m.Metrics.AddFailed(dest, len(status.Response.Failures))
+		}
+		log.Debugf("%s finished, %d of %d documents written", task, written, progress.Total)
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+package migrate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// exit codes of esm
+const (
+	ExitSuccess = 0 // every document was migrated
+	ExitFatal   = 1 // the migration was aborted
+	ExitPartial = 2 // the migration finished, but some documents failed or were skipped
+)
+
+type IndexReport struct {
+	Index           string  `json:"index"`
+	Scrolled        int64   `json:"scrolled"`
+	Written         int64   `json:"written"`
+	Failed          int64   `json:"failed"`
+	Skipped         int64   `json:"skipped"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	DocsPerSecond   float64 `json:"docs_per_second"`
+}
+
+type Report struct {
+	Status          string        `json:"status"`
+	ExitCode        int           `json:"exit_code"`
+	Error           string        `json:"error,omitempty"`
+	Started         time.Time     `json:"started"`
+	Finished        time.Time     `json:"finished"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	ScrollErrors    int64         `json:"scroll_errors"`
+	Total           IndexReport   `json:"total"`
+	Indices         []IndexReport `json:"indices"`
+}
+
+// Report summarizes the migration, err is the fatal error the migration
+// was aborted with
+func (m *Metrics) Report(err error) *Report {
+	status := m.Status()
+	finished := time.Now()
+
+	report := &Report{
+		Started:         status.Started,
+		Finished:        finished,
+		DurationSeconds: finished.Sub(status.Started).Seconds(),
+		ScrollErrors:    status.ScrollErrors,
+		Indices:         []IndexReport{},
+	}
+
+	for name, idx := range status.Indices {
+		report.Indices = append(report.Indices, newIndexReport(name, idx.Scrolled, idx.Bulked, idx.Failed, idx.Skipped, idx.Updated.Sub(idx.Started)))
+	}
+	sort.Slice(report.Indices, func(i, j int) bool {
+		return report.Indices[i].Index < report.Indices[j].Index
+	})
+	report.Total = newIndexReport("total", status.DocsScrolled, status.DocsBulked, status.DocsFailed, status.DocsSkipped, finished.Sub(status.Started))
+
+	switch {
+	case errors.Is(err, ErrInterrupted):
+		report.Status = "interrupted"
+		report.ExitCode = ExitFatal
+		report.Error = err.Error()
+	case err != nil:
+		report.Status = "failed"
+		report.ExitCode = ExitFatal
+		report.Error = err.Error()
+	case status.DocsFailed > 0 || status.DocsSkipped > 0 || status.ScrollErrors > 0 ||
+		status.DocsBulked+status.DocsFailed+status.DocsSkipped < status.DocsScrolled:
+		report.Status = "partial"
+		report.ExitCode = ExitPartial
+	default:
+		report.Status = "success"
+		report.ExitCode = ExitSuccess
+	}
+	return report
+}
+
+func newIndexReport(name string, scrolled, written, failed, skipped int64, duration time.Duration) IndexReport {
+	idx := IndexReport{
+		Index:           name,
+		Scrolled:        scrolled,
+		Written:         written,
+		Failed:          failed,
+		Skipped:         skipped,
+		DurationSeconds: duration.Seconds(),
+	}
+	if duration > 0 {
+		idx.DocsPerSecond = float64(written) / duration.Seconds()
+	}
+	return idx
+}
+
+// Print writes the summary as a table
+func (r *Report) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tSCROLLED\tWRITTEN\tFAILED\tSKIPPED\tDURATION\tDOCS/S\t")
+	for _, idx := range append(r.Indices, r.Total) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%s\t%.1f\t\n", idx.Index, idx.Scrolled, idx.Written, idx.Failed, idx.Skipped,
+			(time.Duration(idx.DurationSeconds * float64(time.Second))).Round(time.Millisecond), idx.DocsPerSecond)
+	}
+	tw.Flush()
+
+	if r.ScrollErrors > 0 {
+		fmt.Fprintf(w, "scroll errors: %d\n", r.ScrollErrors)
+	}
+	fmt.Fprintf(w, "status: %s, exit code: %d\n", r.Status, r.ExitCode)
+}
+
+// WriteFile saves the summary as json
+func (r *Report) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,97 @@
+package migrate
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// IndexRestore keeps the settings of target indexes that were changed to
+// speed up the migration, they are put back when the migration ends, fails
+// or is interrupted
+type IndexRestore struct {
+	lock     sync.Mutex
+	api      ESAPI
+	names    []string
+	settings map[string]map[string]interface{}
+}
+
+// prepareIndexSettings turns off refresh, replicas and translog fsync of the
+// index settings for bulk loading, and returns the values to restore, nil
+// values reset the setting to its default
+func prepareIndexSettings(index map[string]interface{}) map[string]interface{} {
+	durability := index["translog.durability"]
+	if translog, ok := index["translog"].(map[string]interface{}); ok {
+		if v, ok := translog["durability"]; ok && durability == nil {
+			durability = v
+		}
+		delete(translog, "durability")
+	}
+
+	restore := map[string]interface{}{
+		"refresh_interval":    index["refresh_interval"],
+		"number_of_replicas":  index["number_of_replicas"],
+		"translog.durability": durability,
+	}
+
+	index["refresh_interval"] = -1
+	index["number_of_replicas"] = 0
+	index["translog.durability"] = "async"
+	return restore
+}
+
+// Track remembers the settings to restore on the index
+func (r *IndexRestore) Track(api ESAPI, name string, settings map[string]interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.settings == nil {
+		r.settings = map[string]map[string]interface{}{}
+	}
+	if _, ok := r.settings[name]; !ok {
+		r.names = append(r.names, name)
+	}
+	r.api = api
+	r.settings[name] = settings
+}
+
+// Restore puts back the settings of the tracked indexes, every index is
+// restored once so it is safe to call it again. With a green timeout it waits
+// for the replicas to be allocated.
+func (r *IndexRestore) Restore(refresh bool, greenTimeout time.Duration) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var errs []error
+	restored := []string{}
+	for _, name := range r.names {
+		settings := getEmptyIndexSettings()
+		for k, v := range r.settings[name] {
+			settings["settings"].(map[string]interface{})["index"].(map[string]interface{})[k] = v
+		}
+		log.Debug("restore index settings, ", name, settings)
+		if err := r.api.UpdateIndexSettings(name, settings); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		restored = append(restored, name)
+		if refresh {
+			r.api.Refresh(name)
+		}
+	}
+	r.names = nil
+	r.settings = nil
+
+	if len(restored) > 0 {
+		log.Infof("restored settings of %s", strings.Join(restored, ","))
+		if greenTimeout > 0 {
+			log.Infof("waiting up to %s for %s to become green", greenTimeout, strings.Join(restored, ","))
+			if err := r.api.WaitForStatus(strings.Join(restored, ","), "green", greenTimeout); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,172 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raminhz90/esm/esmtest"
+	"github.com/raminhz90/esm/migrate"
+)
+
+// concurrent is a migration of several readers and workers through a small
+// queue, run with -race
+var concurrent = []string{"-x", "orders", "--sliced_scroll_size", "4", "-c", "50", "-w", "4", "--buffer_count", "100"}
+
+// runWithin runs the migrator and fails if it doesn't return in time
+func runWithin(t *testing.T, ctx context.Context, m *migrate.Migrator, d time.Duration) (*migrate.Report, error) {
+	t.Helper()
+	type result struct {
+		report *migrate.Report
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		report, err := m.Run(ctx)
+		done <- result{report, err}
+	}()
+	select {
+	case r := <-done:
+		return r.report, r.err
+	case <-time.After(d):
+		t.Fatalf("migration didn't return within %s", d)
+		return nil, nil
+	}
+}
+
+func newServers(t *testing.T, docs int) (*esmtest.Server, *esmtest.Server) {
+	t.Helper()
+	source := esmtest.NewServer("7.10.2")
+	t.Cleanup(source.Close)
+	source.AddDocs("orders", docs)
+	target := esmtest.NewServer("7.10.2")
+	t.Cleanup(target.Close)
+	return source, target
+}
+
+func TestRunConcurrent(t *testing.T) {
+	source, target := newServers(t, 5000)
+	c := newConfig(t, append([]string{"-s", source.URL, "-d", target.URL}, concurrent...)...)
+
+	report, err := runWithin(t, context.Background(), migrate.NewMigrator(c), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Scrolled != 5000 || report.Total.Written != 5000 {
+		t.Errorf("report counts %+v, expected 5000 scrolled and written", report.Total)
+	}
+	checkCount(t, target, "orders", 5000)
+}
+
+func TestRunStop(t *testing.T) {
+	source, target := newServers(t, 20000)
+	target.Delay(20 * time.Millisecond)
+	c := newConfig(t, append([]string{"-s", source.URL, "-d", target.URL}, concurrent...)...)
+	m := migrate.NewMigrator(c)
+	time.AfterFunc(200*time.Millisecond, func() { m.Stop(nil) })
+
+	report, err := runWithin(t, context.Background(), m, 30*time.Second)
+	if !errors.Is(err, migrate.ErrInterrupted) {
+		t.Fatalf("stopped migration returned %v, expected %v", err, migrate.ErrInterrupted)
+	}
+	if report.Total.Written == 0 || report.Total.Written >= 20000 {
+		t.Errorf("%d documents written, expected part of them", report.Total.Written)
+	}
+	// the documents already read are written
+	if report.Total.Written != report.Total.Scrolled {
+		t.Errorf("%d documents scrolled but %d written", report.Total.Scrolled, report.Total.Written)
+	}
+	checkCount(t, target, "orders", int(report.Total.Written))
+}
+
+func TestRunCancel(t *testing.T) {
+	source, target := newServers(t, 20000)
+	target.Delay(20 * time.Millisecond)
+	c := newConfig(t, append([]string{"-s", source.URL, "-d", target.URL}, concurrent...)...)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	report, err := runWithin(t, ctx, migrate.NewMigrator(c), 30*time.Second)
+	if !errors.Is(err, migrate.ErrInterrupted) {
+		t.Fatalf("cancelled migration returned %v, expected %v", err, migrate.ErrInterrupted)
+	}
+	if report.Total.Written >= 20000 {
+		t.Errorf("all documents written, the migration wasn't cancelled")
+	}
+}
+
+func TestRunFailingReader(t *testing.T) {
+	source, target := newServers(t, 5000)
+	source.Fail("_search/scroll", 5, 500)
+	c := newConfig(t, append([]string{"-s", source.URL, "-d", target.URL, "--scroll_retries", "0"}, concurrent...)...)
+
+	report, err := runWithin(t, context.Background(), migrate.NewMigrator(c), 30*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "scroll failed") {
+		t.Fatalf("migration of a failing source returned %v, expected the scroll failure", err)
+	}
+	if report.Total.Written != report.Total.Scrolled || report.Total.Written >= 5000 {
+		t.Errorf("report counts %+v, expected the documents read only to be written", report.Total)
+	}
+}
+
+func TestRunRetriedReader(t *testing.T) {
+	source, target := newServers(t, 250)
+	source.Fail("_search/scroll", 1, 503)
+	// the source recovers before the first retry
+	time.AfterFunc(200*time.Millisecond, func() { source.Fail("_search/scroll", 0, 0) })
+	c := newConfig(t, "-s", source.URL, "-d", target.URL, "-x", "orders", "-c", "10")
+	m := migrate.NewMigrator(c)
+
+	report, err := runWithin(t, context.Background(), m, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "success" || report.ExitCode != migrate.ExitSuccess || report.ScrollErrors != 0 {
+		t.Errorf("report %s, exit code %d, %d scroll errors, expected a success of retried scrolls",
+			report.Status, report.ExitCode, report.ScrollErrors)
+	}
+	if retries := m.Metrics.Status().ScrollRetries; retries == 0 {
+		t.Error("no scroll was retried")
+	}
+	checkCount(t, target, "orders", 250)
+}
+
+func TestRunFailingWriter(t *testing.T) {
+	source, target := newServers(t, 5000)
+	target.Fail("_bulk", 3, 500)
+	c := newConfig(t, append([]string{"-s", source.URL, "-d", target.URL, "--bulk_retries", "1"}, concurrent...)...)
+
+	report, err := runWithin(t, context.Background(), migrate.NewMigrator(c), 30*time.Second)
+	if err == nil || !strings.Contains(err.Error(), "giving up after 2 attempts") {
+		t.Fatalf("migration to a failing target returned %v, expected the bulk failure", err)
+	}
+	if report.Total.Written >= 5000 {
+		t.Errorf("all documents written to a failing target")
+	}
+	if count := target.Count("orders"); int64(count) != report.Total.Written {
+		t.Errorf("target has %d documents, %d reported written", count, report.Total.Written)
+	}
+}
+
+func TestRunFailingOutputFile(t *testing.T) {
+	source, _ := newServers(t, 20000)
+	output := filepath.Join(t.TempDir(), "missing", "dump.json")
+	c := newConfig(t, append([]string{"-s", source.URL, "-o", output}, concurrent...)...)
+
+	if _, err := runWithin(t, context.Background(), migrate.NewMigrator(c), 30*time.Second); err == nil {
+		t.Fatal("migration to a failing output succeeded")
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	c, err := migrate.NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ScrollRetries != 5 || c.ScrollTime != "10m" {
+		t.Errorf("config has %d scroll retries and a scroll time of %s, expected the defaults", c.ScrollRetries, c.ScrollTime)
+	}
+}
@@ -0,0 +1,379 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const s3Scheme = "s3://"
+
+func isS3Path(name string) bool {
+	return strings.HasPrefix(name, s3Scheme)
+}
+
+// parseS3Path splits s3://bucket/prefix into bucket and prefix
+func parseS3Path(name string) (bucket string, prefix string, err error) {
+	bucket, prefix, _ = strings.Cut(strings.TrimPrefix(name, s3Scheme), "/")
+	if len(bucket) == 0 {
+		return "", "", fmt.Errorf("no bucket in %s, ie: s3://bucket/prefix", name)
+	}
+	return bucket, strings.TrimSuffix(prefix, "/"), nil
+}
+
+// s3Chunk is the name of the nth object of a dump
+func s3Chunk(prefix string, n int) string {
+	return path.Join(prefix, fmt.Sprintf("part-%05d.json", n))
+}
+
+// newS3Client connects to --s3_endpoint, with the keys of --s3_auth or else
+// of the environment, ~/.aws/credentials or the IAM role
+func (m *Migrator) newS3Client() (*minio.Client, error) {
+	c := m.Config
+	endpoint, err := url.Parse(c.S3Endpoint)
+	if err != nil || len(endpoint.Host) == 0 {
+		return nil, fmt.Errorf("invalid --s3_endpoint %s, ie: https://s3.amazonaws.com", c.S3Endpoint)
+	}
+
+	var creds *credentials.Credentials
+	if len(c.S3Auth) > 0 {
+		key, secret, _ := strings.Cut(c.S3Auth, ":")
+		creds = credentials.NewStaticV4(key, secret, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+	return minio.New(endpoint.Host, &minio.Options{
+		Creds:  creds,
+		Secure: endpoint.Scheme == "https",
+		Region: c.S3Region,
+	})
+}
+
+// s3Writer uploads the dump into objects of --s3_chunk_size, every object
+// is streamed as a multipart upload. A new object is started after a whole
+// line only, so that every object can be loaded on its own.
+type s3Writer struct {
+	ctx       context.Context
+	client    *minio.Client
+	bucket    string
+	prefix    string
+	chunkSize int64
+	options   minio.PutObjectOptions
+
+	next      int
+	key       string
+	written   int64
+	lineStart bool
+	pipe      *io.PipeWriter
+	result    chan error
+}
+
+// newS3Writer returns the writer of an s3 output, the objects of an earlier
+// dump with the same prefix are kept and the new objects numbered after them
+func (m *Migrator) newS3Writer(ctx context.Context) (*s3Writer, error) {
+	c := m.Config
+	bucket, prefix, err := parseS3Path(c.DumpOutFile)
+	if err != nil {
+		return nil, err
+	}
+	client, err := m.newS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &s3Writer{
+		ctx:       ctx,
+		client:    client,
+		bucket:    bucket,
+		prefix:    prefix,
+		chunkSize: int64(c.S3ChunkSize) * 1024 * 1024,
+		options: minio.PutObjectOptions{
+			ContentType:           "application/x-ndjson",
+			PartSize:              uint64(c.S3PartSize) * 1024 * 1024,
+			NumThreads:            uint(c.S3Concurrency),
+			ConcurrentStreamParts: c.S3Concurrency > 1,
+		},
+		lineStart: true,
+	}
+
+	listPrefix := prefix
+	if len(listPrefix) > 0 {
+		listPrefix += "/"
+	}
+	existing := map[string]bool{}
+	for object := range client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: listPrefix}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", c.DumpOutFile, object.Err)
+		}
+		existing[object.Key] = true
+	}
+	for existing[s3Chunk(prefix, w.next)] {
+		w.next++
+	}
+	if w.next > 0 {
+		log.Infof("%s has %d objects, appending after them", c.DumpOutFile, w.next)
+	}
+	return w, nil
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		if w.pipe == nil || (w.written >= w.chunkSize && w.lineStart) {
+			if err := w.finish(); err != nil {
+				return total - len(p), err
+			}
+			w.start()
+		}
+
+		n := len(p)
+		if w.written >= w.chunkSize {
+			// the object is full, only the rest of the line goes into it
+			if i := bytes.IndexByte(p, '\n'); i >= 0 {
+				n = i + 1
+			}
+		}
+		written, err := w.pipe.Write(p[:n])
+		w.written += int64(written)
+		if written > 0 {
+			w.lineStart = p[written-1] == '\n'
+		}
+		p = p[written:]
+		if err != nil {
+			return total - len(p), err
+		}
+	}
+	return total, nil
+}
+
+// start uploads the next object from a pipe, its size is unknown
+func (w *s3Writer) start() {
+	reader, writer := io.Pipe()
+	w.key = s3Chunk(w.prefix, w.next)
+	w.next++
+	w.written = 0
+	w.pipe = writer
+	w.result = make(chan error, 1)
+	log.Debugf("uploading s3://%s/%s", w.bucket, w.key)
+
+	go func(key string) {
+		_, err := w.client.PutObject(w.ctx, w.bucket, key, reader, -1, w.options)
+		if err != nil {
+			err = fmt.Errorf("failed to upload s3://%s/%s: %v", w.bucket, key, err)
+			// minio-go cancels its requests before aborting the multipart
+			// upload of a failed stream, the uploaded parts would be kept
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			if removeErr := w.client.RemoveIncompleteUpload(ctx, w.bucket, key); removeErr != nil {
+				log.Warnf("failed to remove the incomplete upload of s3://%s/%s: %v", w.bucket, key, removeErr)
+			}
+			cancel()
+		}
+		reader.CloseWithError(err)
+		w.result <- err
+	}(w.key)
+}
+
+// finish completes the upload of the current object
+func (w *s3Writer) finish() error {
+	if w.pipe == nil {
+		return nil
+	}
+	w.pipe.Close()
+	w.pipe = nil
+	if err := <-w.result; err != nil {
+		return err
+	}
+	log.Debugf("uploaded s3://%s/%s, %d bytes", w.bucket, w.key, w.written)
+	return nil
+}
+
+// Close completes the upload of the last object, it does nothing once the
+// upload is completed or aborted
+func (w *s3Writer) Close() error {
+	return w.finish()
+}
+
+// Abort fails the upload of the current object, so that no truncated object
+// is left, the objects completed before are kept
+func (w *s3Writer) Abort(err error) {
+	if w.pipe == nil {
+		return
+	}
+	if err == nil {
+		err = errors.New("dump stopped")
+	}
+	w.pipe.CloseWithError(err)
+	w.pipe = nil
+	<-w.result
+	log.Warnf("upload of s3://%s/%s aborted: %v", w.bucket, w.key, err)
+}
+
+// s3Part is a range of an object downloaded in parallel to other parts
+type s3Part struct {
+	key    string
+	offset int64
+	size   int64
+	// last part of its object
+	last bool
+	data []byte
+	err  error
+	done chan struct{}
+}
+
+// s3Reader reads the objects of an s3 input one after the other, while
+// --s3_concurrency parts of them are downloaded ahead in parallel
+type s3Reader struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	parts   chan *s3Part
+	slots   chan struct{}
+	current []byte
+	err     error
+}
+
+// newS3Reader reads the object named by the s3 input, or else all objects
+// below it in the order of their names
+func (m *Migrator) newS3Reader(ctx context.Context) (*s3Reader, error) {
+	c := m.Config
+	bucket, prefix, err := parseS3Path(c.DumpInputFile)
+	if err != nil {
+		return nil, err
+	}
+	client, err := m.newS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	// listing stops early when the input names an object
+	listCtx, stopListing := context.WithCancel(ctx)
+	defer stopListing()
+	objects := []minio.ObjectInfo{}
+	for object := range client.ListObjects(listCtx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", c.DumpInputFile, object.Err)
+		}
+		if object.Key == prefix {
+			objects = []minio.ObjectInfo{object}
+			break
+		}
+		if len(prefix) == 0 || strings.HasPrefix(object.Key, prefix+"/") {
+			objects = append(objects, object)
+		}
+	}
+	if len(objects) == 0 {
+		return nil, fmt.Errorf("no objects at %s", c.DumpInputFile)
+	}
+	log.Debugf("reading %d objects of %s", len(objects), c.DumpInputFile)
+
+	partSize := int64(c.S3PartSize) * 1024 * 1024
+	concurrency := c.S3Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r := &s3Reader{
+		ctx:    ctx,
+		cancel: cancel,
+		parts:  make(chan *s3Part, concurrency),
+		slots:  make(chan struct{}, concurrency),
+	}
+
+	go func() {
+		defer close(r.parts)
+		for _, object := range objects {
+			for offset := int64(0); offset < object.Size; offset += partSize {
+				part := &s3Part{key: object.Key, offset: offset, size: partSize, done: make(chan struct{})}
+				if offset+partSize >= object.Size {
+					part.size = object.Size - offset
+					part.last = true
+				}
+				// a slot is freed once the part is read
+				select {
+				case r.slots <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+				go part.download(ctx, client, bucket)
+				select {
+				case r.parts <- part:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return r, nil
+}
+
+// download gets the range of the part, a missing newline at the end of an
+// object is added so that objects don't run into each other
+func (p *s3Part) download(ctx context.Context, client *minio.Client, bucket string) {
+	defer close(p.done)
+	options := minio.GetObjectOptions{}
+	options.SetRange(p.offset, p.offset+p.size-1)
+	object, err := client.GetObject(ctx, bucket, p.key, options)
+	if err == nil {
+		p.data, err = io.ReadAll(object)
+		object.Close()
+	}
+	if err != nil {
+		p.err = fmt.Errorf("failed to download s3://%s/%s at %d: %v", bucket, p.key, p.offset, err)
+		return
+	}
+	if p.last && len(p.data) > 0 && p.data[len(p.data)-1] != '\n' {
+		p.data = append(p.data, '\n')
+	}
+}
+
+func (r *s3Reader) Read(b []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		// parts stop coming and fail once ctx is done, the input is not
+		// read to its end then
+		part, ok := <-r.parts
+		if !ok {
+			r.err = io.EOF
+			if err := r.ctx.Err(); err != nil {
+				r.err = err
+			}
+			continue
+		}
+		<-part.done
+		<-r.slots
+		if part.err != nil {
+			r.err = part.err
+			if err := r.ctx.Err(); err != nil {
+				r.err = err
+			}
+			continue
+		}
+		r.current = part.data
+	}
+	n := copy(b, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// Close stops the downloads
+func (r *s3Reader) Close() error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,343 @@
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Stub is an in-memory s3 of the requests of minio-go, objects are stored
+// by bucket/key
+type s3Stub struct {
+	*httptest.Server
+	lock    sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	// keys of the uploads in progress by upload id
+	uploadKeys map[string]string
+	uploaded   int
+	aborted    int
+	// object downloads wait for the request to be cancelled
+	hang bool
+}
+
+func newS3Stub(t *testing.T) *s3Stub {
+	s := &s3Stub{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}, uploadKeys: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// object returns the stored object, false if there is none
+func (s *s3Stub) object(key string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.objects[key]
+	return data, ok
+}
+
+// body reads the payload, decoding aws-chunked streaming uploads
+func body(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil || !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return data, err
+	}
+	decoded := []byte{}
+	chunks := bufio.NewReader(bytes.NewReader(data))
+	for {
+		header, err := chunks.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(strings.SplitN(header, ";", 2)[0]), 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return decoded, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(chunks, chunk); err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, chunk[:size]...)
+	}
+}
+
+func (s *s3Stub) serve(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	name := bucket + "/" + key
+
+	switch {
+	case len(key) == 0 && r.Method == http.MethodGet && query.Has("uploads"):
+		s.listUploads(w, bucket, query.Get("prefix"))
+
+	case len(key) == 0 && r.Method == http.MethodGet:
+		s.list(w, bucket, query.Get("prefix"))
+
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.lock.Lock()
+		s.uploaded++
+		id := fmt.Sprintf("upload-%d", s.uploaded)
+		s.uploads[id] = map[int][]byte{}
+		s.uploadKeys[id] = name
+		s.lock.Unlock()
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, bucket, key, id)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		data, err := body(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		part, _ := strconv.Atoi(query.Get("partNumber"))
+		s.lock.Lock()
+		parts, ok := s.uploads[query.Get("uploadId")]
+		if ok {
+			parts[part] = data
+		}
+		s.lock.Unlock()
+		if !ok {
+			http.Error(w, "no such upload", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf(`"part-%d"`, part))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.lock.Lock()
+		parts, ok := s.uploads[query.Get("uploadId")]
+		delete(s.uploads, query.Get("uploadId"))
+		delete(s.uploadKeys, query.Get("uploadId"))
+		numbers := []int{}
+		for n := range parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		data := []byte{}
+		for _, n := range numbers {
+			data = append(data, parts[n]...)
+		}
+		if ok {
+			s.objects[name] = data
+		}
+		s.lock.Unlock()
+		if !ok {
+			http.Error(w, "no such upload", http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>"object"</ETag></CompleteMultipartUploadResult>`, bucket, key)
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.lock.Lock()
+		delete(s.uploads, query.Get("uploadId"))
+		delete(s.uploadKeys, query.Get("uploadId"))
+		s.aborted++
+		s.lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		data, err := body(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.lock.Lock()
+		s.objects[name] = data
+		s.lock.Unlock()
+		w.Header().Set("ETag", `"object"`)
+
+	case r.Method == http.MethodGet:
+		s.lock.Lock()
+		data, ok := s.objects[name]
+		hang := s.hang
+		s.lock.Unlock()
+		if hang {
+			<-r.Context().Done()
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `<Error><Code>NoSuchKey</Code><Key>%s</Key></Error>`, key)
+			return
+		}
+		w.Header().Set("ETag", `"object"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+
+	default:
+		http.Error(w, "unsupported", http.StatusNotImplemented)
+	}
+}
+
+func (s *s3Stub) list(w http.ResponseWriter, bucket string, prefix string) {
+	type content struct {
+		Key          string
+		Size         int64
+		ETag         string
+		LastModified string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		IsTruncated bool
+		Contents    []content
+	}{Name: bucket, Prefix: prefix}
+
+	s.lock.Lock()
+	for name, data := range s.objects {
+		if key := strings.TrimPrefix(name, bucket+"/"); key != name && strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{key, int64(len(data)), `"object"`, time.Now().UTC().Format(time.RFC3339)})
+		}
+	}
+	s.lock.Unlock()
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	result.KeyCount = len(result.Contents)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (s *s3Stub) listUploads(w http.ResponseWriter, bucket string, prefix string) {
+	type upload struct {
+		Key      string
+		UploadId string
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListMultipartUploadsResult"`
+		Bucket      string
+		Prefix      string
+		IsTruncated bool
+		Upload      []upload
+	}{Bucket: bucket, Prefix: prefix}
+
+	s.lock.Lock()
+	for id, name := range s.uploadKeys {
+		if key := strings.TrimPrefix(name, bucket+"/"); key != name && strings.HasPrefix(key, prefix) {
+			result.Upload = append(result.Upload, upload{key, id})
+		}
+	}
+	s.lock.Unlock()
+	xml.NewEncoder(w).Encode(result)
+}
+
+// s3Migrator returns a migrator of the s3 stub
+func s3Migrator(s *s3Stub) *Migrator {
+	return &Migrator{Config: &Config{
+		S3Endpoint:    s.URL,
+		S3Region:      "us-east-1",
+		S3Auth:        "key:secret",
+		S3ChunkSize:   1024,
+		S3PartSize:    16,
+		S3Concurrency: 4,
+		DumpOutFile:   "s3://dumps/orders",
+		DumpInputFile: "s3://dumps/orders",
+	}}
+}
+
+func TestS3Upload(t *testing.T) {
+	s := newS3Stub(t)
+	w, err := s3Migrator(s).newS3Writer(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := strings.Repeat(`{"_index":"orders","_id":"1","_source":{}}`+"\n", 100)
+	if _, err := w.Write([]byte(dump)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, ok := s.object("dumps/orders/part-00000.json"); !ok || string(data) != dump {
+		t.Errorf("uploaded object has %d bytes, expected %d", len(data), len(dump))
+	}
+
+	// the objects of an earlier dump are kept
+	w, err = s3Migrator(s).newS3Writer(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.next != 1 {
+		t.Errorf("the next object is %d, expected 1 after the earlier dump", w.next)
+	}
+}
+
+func TestS3UploadAbort(t *testing.T) {
+	s := newS3Stub(t)
+	w, err := s3Migrator(s).newS3Writer(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte(`{"_index":"orders","_id":"1","_source":{}}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	abortDumpOutput(w, errors.New("bulk failed"))
+
+	if _, ok := s.object("dumps/orders/part-00000.json"); ok {
+		t.Error("the object of an aborted dump was uploaded")
+	}
+	s.lock.Lock()
+	if s.aborted != 1 || len(s.uploads) != 0 {
+		t.Errorf("%d uploads aborted and %d left, expected the upload aborted", s.aborted, len(s.uploads))
+	}
+	s.lock.Unlock()
+	// closing again does nothing
+	if err := w.Close(); err != nil {
+		t.Errorf("close of an aborted upload returned %v", err)
+	}
+}
+
+func TestS3DownloadCancel(t *testing.T) {
+	s := newS3Stub(t)
+	s.objects["dumps/orders/part-00000.json"] = []byte(`{"_index":"orders","_id":"1","_source":{}}` + "\n")
+	s.hang = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := s3Migrator(s).newS3Reader(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := io.ReadAll(r); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled download returned %v, expected %v", err, context.Canceled)
+	}
+	if waited := time.Since(start); waited > 5*time.Second {
+		t.Errorf("cancelled download returned after %s", waited)
+	}
+}
+
+func TestS3Download(t *testing.T) {
+	s := newS3Stub(t)
+	s.objects["dumps/orders/part-00000.json"] = []byte(`{"_id":"1"}` + "\n" + `{"_id":"2"}`)
+	s.objects["dumps/orders/part-00001.json"] = []byte(`{"_id":"3"}` + "\n")
+	s.objects["dumps/orders-old/part-00000.json"] = []byte(`{"_id":"4"}` + "\n")
+
+	r, err := s3Migrator(s).newS3Reader(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// objects don't run into each other and other prefixes are not read
+	if expected := `{"_id":"1"}` + "\n" + `{"_id":"2"}` + "\n" + `{"_id":"3"}` + "\n"; string(data) != expected {
+		t.Errorf("download returned %q, expected %q", data, expected)
+	}
+}
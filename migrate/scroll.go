@@ -0,0 +1,215 @@
+package migrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+	"github.com/raminhz90/esm/util"
+)
+
+type ScrollAPI interface {
+	GetScrollId() string
+	GetHitsTotal() int
+	GetDocs() []Hit
+	ProcessScrollResult(c *Migrator, bar *pb.ProgressBar)
+	Next(ctx context.Context, c *Migrator, bar *pb.ProgressBar) (done bool, err error)
+	Err() error
+}
+
+// ScrollError is an error response to a scroll request
+type ScrollError struct {
+	Status int
+	Body   string
+}
+
+func (e *ScrollError) Error() string {
+	return fmt.Sprintf("scroll failed, status: %d, %s", e.Status, util.SubString(e.Body, 0, 500))
+}
+
+// Expired is true when the search context is gone on source, the scroll
+// can't be continued
+func (e *ScrollError) Expired() bool {
+	return strings.Contains(e.Body, "search_context_missing_exception") ||
+		strings.Contains(e.Body, "SearchContextMissingException")
+}
+
+// Transient errors may succeed when retried
+func (e *ScrollError) Transient() bool {
+	return !e.Expired() && (e.Status == 0 || e.Status == http.StatusTooManyRequests || e.Status >= 500)
+}
+
+// Err returns the error of the response, or of all shards when none of them
+// succeeded
+func (s *Scroll) Err() error {
+	if s.Error != nil {
+		body, _ := json.Marshal(s.Error)
+		return &ScrollError{Status: s.Status, Body: string(body)}
+	}
+	if s.Shards.Total > 0 && s.Shards.Successful == 0 && len(s.Shards.Failures) > 0 {
+		body, _ := json.Marshal(s.Shards.Failures)
+		return &ScrollError{Status: s.Shards.Failures[0].Status, Body: string(body)}
+	}
+	return nil
+}
+
+// nextPage fetches the next page of the scroll, transient errors are
+// retried with backoff until ctx is done and an expired scroll fails at once
+func nextPage(ctx context.Context, c *Migrator, scrollId string) (ScrollAPI, error) {
+	for attempt := 0; ; attempt++ {
+		var page ScrollAPI
+		result, err := c.SourceESAPI.NextScroll(c.Config.ScrollTime, scrollId)
+		if err == nil {
+			page = result.(ScrollAPI)
+			err = page.Err()
+		}
+		if err == nil {
+			return page, nil
+		}
+
+		// the migration was stopped, nothing to retry
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+
+		if scrollErr, ok := err.(*ScrollError); ok {
+			if scrollErr.Expired() {
+				c.Metrics.AddScrollError()
+				return nil, fmt.Errorf("scroll context expired on source, try a longer --time: %v", err)
+			}
+			if !scrollErr.Transient() {
+				c.Metrics.AddScrollError()
+				return nil, err
+			}
+		}
+		if attempt >= c.Config.ScrollRetries {
+			c.Metrics.AddScrollError()
+			return nil, fmt.Errorf("scroll failed after %d attempts: %v", attempt+1, err)
+		}
+
+		// a retried error is no scroll error unless the retries give up
+		c.Metrics.AddScrollRetry()
+		wait := backoff(attempt)
+		log.Warnf("scroll failed, retry in %v: %v", wait, err)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (scroll *Scroll) GetHitsTotal() int {
+	return scroll.Hits.Total
+}
+
+func (scroll *Scroll) GetScrollId() string {
+	return scroll.ScrollId
+}
+
+func (scroll *Scroll) GetDocs() []Hit {
+	return scroll.Hits.Docs
+}
+
+func (scroll *ScrollV7) GetHitsTotal() int {
+	return scroll.Hits.Total.Value
+}
+
+func (scroll *ScrollV7) GetScrollId() string {
+	return scroll.ScrollId
+}
+
+func (scroll *ScrollV7) GetDocs() []Hit {
+	return scroll.Hits.Docs
+}
+
+// Stream from source es instance. "done" is an indicator that the stream is
+// over
+func (s *Scroll) ProcessScrollResult(c *Migrator, bar *pb.ProgressBar) {
+
+	//update progress bar
+	bar.Add(len(s.Hits.Docs))
+
+	// show any failures
+	for _, failure := range s.Shards.Failures {
+		reason, _ := json.Marshal(failure.Reason)
+		log.Errorf(string(reason))
+		c.Metrics.AddScrollError()
+	}
+
+	// write all the docs into the queue, blocks while it is full
+	for i := range s.Hits.Docs {
+		c.Metrics.AddScrolled(s.Hits.Docs[i].Index, 1)
+		c.Docs.Put(&s.Hits.Docs[i])
+	}
+}
+
+func (s *Scroll) Next(ctx context.Context, c *Migrator, bar *pb.ProgressBar) (done bool, err error) {
+
+	scroll, err := nextPage(ctx, c, s.ScrollId)
+	if err != nil {
+		return false, err
+	}
+
+	//update scrollId
+	if len(scroll.GetScrollId()) > 0 {
+		s.ScrollId = scroll.GetScrollId()
+	}
+
+	docs := scroll.GetDocs()
+	if docs == nil || len(docs) <= 0 {
+		log.Debug("scroll result is empty")
+		return true, nil
+	}
+
+	scroll.ProcessScrollResult(c, bar)
+
+	return false, nil
+}
+
+// Stream from source es instance. "done" is an indicator that the stream is
+// over
+func (s *ScrollV7) ProcessScrollResult(c *Migrator, bar *pb.ProgressBar) {
+
+	//update progress bar
+	bar.Add(len(s.Hits.Docs))
+
+	// show any failures
+	for _, failure := range s.Shards.Failures {
+		reason, _ := json.Marshal(failure.Reason)
+		log.Errorf(string(reason))
+		c.Metrics.AddScrollError()
+	}
+
+	// write all the docs into the queue, blocks while it is full
+	for i := range s.Hits.Docs {
+		c.Metrics.AddScrolled(s.Hits.Docs[i].Index, 1)
+		c.Docs.Put(&s.Hits.Docs[i])
+	}
+}
+
+func (s *ScrollV7) Next(ctx context.Context, c *Migrator, bar *pb.ProgressBar) (done bool, err error) {
+
+	scroll, err := nextPage(ctx, c, s.ScrollId)
+	if err != nil {
+		return false, err
+	}
+
+	//update scrollId
+	if len(scroll.GetScrollId()) > 0 {
+		s.ScrollId = scroll.GetScrollId()
+	}
+
+	docs := scroll.GetDocs()
+	if docs == nil || len(docs) <= 0 {
+		log.Debug("scroll result is empty")
+		return true, nil
+	}
+
+	scroll.ProcessScrollResult(c, bar)
+
+	return false, nil
+}
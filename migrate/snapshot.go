@@ -0,0 +1,231 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	log "github.com/cihub/seelog"
+)
+
+// majors of elasticsearch in order, a snapshot can be restored by the same
+// major and the next one
+var majorVersions = []string{"1", "2", "5", "6", "7", "8", "9"}
+
+// snapshotCompatible tells if a snapshot taken by source can be restored on
+// target
+func snapshotCompatible(source string, target string) bool {
+	sourceMajor := strings.Split(source, ".")[0]
+	targetMajor := strings.Split(target, ".")[0]
+	for i, major := range majorVersions {
+		if major != sourceMajor {
+			continue
+		}
+		if targetMajor == major {
+			return true
+		}
+		return i+1 < len(majorVersions) && targetMajor == majorVersions[i+1]
+	}
+	return false
+}
+
+// restoreRename returns the rename of the restore, -y renames a single index
+func (m *Migrator) restoreRename() (pattern string, replacement string) {
+	c := m.Config
+	if len(c.RenamePattern) > 0 {
+		return c.RenamePattern, c.RenameReplacement
+	}
+	if len(c.TargetIndexName) > 0 {
+		return "(.+)", c.TargetIndexName
+	}
+	return "", ""
+}
+
+// restoredName returns the name of the index on target after the restore,
+// the java regex of --rename_pattern is applied as go regex
+func (m *Migrator) restoredName(name string) string {
+	pattern, replacement := m.restoreRename()
+	if len(pattern) == 0 {
+		return name
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return name
+	}
+	return re.ReplaceAllString(name, replacement)
+}
+
+// migrateSnapshot snapshots the source indexes into the shared repository
+// and restores them on target, the bars show the bytes of the snapshot and
+// of the restore
+func (m *Migrator) migrateSnapshot(ctx context.Context, snapshotBar *pb.ProgressBar, restoreBar *pb.ProgressBar) error {
+	c := m.Config
+
+	indexNames, indexCount, _, err := m.SourceESAPI.GetIndexMappings(c.CopyAllIndexes, c.SourceIndexNames)
+	if err != nil {
+		return err
+	}
+	if indexCount == 0 {
+		return fmt.Errorf("index not exists, %s", c.SourceIndexNames)
+	}
+	if indexCount > 1 && len(c.TargetIndexName) > 0 && len(c.RenamePattern) == 0 {
+		return errors.New("--dest_index needs a single source index with --mode=snapshot, use --rename_pattern to rename several")
+	}
+	c.SourceIndexNames = indexNames
+	names := strings.Split(indexNames, ",")
+
+	// restoring fails on existing indexes, --force deletes them first
+	targetIndexSettings, _ := m.TargetESAPI.GetIndexSettings("_all")
+	existing := []string{}
+	restored := []string{}
+	for _, name := range names {
+		target := m.restoredName(name)
+		restored = append(restored, target)
+		if target != name {
+			m.Metrics.MapIndex(target, name)
+		}
+		if targetIndexSettings == nil {
+			continue
+		}
+		if _, ok := (*targetIndexSettings)[target]; ok {
+			existing = append(existing, target)
+		}
+	}
+	if len(existing) > 0 {
+		if !c.RecreateIndex {
+			return fmt.Errorf("index %s exists on target, use --force to replace it with the snapshot", strings.Join(existing, ","))
+		}
+		if err := m.deleteTargetIndices(existing); err != nil {
+			return err
+		}
+	}
+
+	if len(c.SnapshotPath) > 0 {
+		// target only reads the repository source writes into
+		settings := map[string]interface{}{"location": c.SnapshotPath}
+		if !c.DryRun {
+			if err := m.SourceESAPI.CreateRepository(c.SnapshotRepository, map[string]interface{}{"type": "fs", "settings": settings}); err != nil {
+				return err
+			}
+		}
+		settings = map[string]interface{}{"location": c.SnapshotPath, "readonly": true}
+		if err := m.TargetESAPI.CreateRepository(c.SnapshotRepository, map[string]interface{}{"type": "fs", "settings": settings}); err != nil {
+			return err
+		}
+	}
+
+	snapshot := "esm-" + time.Now().Format("20060102150405")
+	pattern, replacement := m.restoreRename()
+
+	if c.DryRun {
+		m.Plan.record("snapshot", indexNames, map[string]interface{}{"repository": c.SnapshotRepository, "snapshot": snapshot, "cluster": "source"})
+		m.TargetESAPI.RestoreSnapshot(c.SnapshotRepository, snapshot, indexNames, pattern, replacement)
+		return m.buildPlan(0)
+	}
+
+	// _count on both sides, the docs of _stats include nested documents
+	counts := map[string]int{}
+	for _, name := range names {
+		count, err := m.SourceESAPI.Count(name, "")
+		if err != nil {
+			return err
+		}
+		counts[name] = count
+	}
+
+	log.Infof("snapshot %s into %s/%s", indexNames, c.SnapshotRepository, snapshot)
+	if m.Progress == nil {
+		snapshotBar.Prefix("Snapshot")
+		snapshotBar.SetUnits(pb.U_BYTES)
+		restoreBar.Prefix("Restore")
+		restoreBar.SetUnits(pb.U_BYTES)
+	}
+	if err := m.SourceESAPI.StartSnapshot(c.SnapshotRepository, snapshot, indexNames); err != nil {
+		return err
+	}
+
+	for {
+		status, err := m.SourceESAPI.SnapshotStatus(c.SnapshotRepository, snapshot)
+		if err != nil {
+			return err
+		}
+		if m.Progress == nil {
+			snapshotBar.Total = status.TotalBytes
+			snapshotBar.Set64(status.ProcessedBytes)
+		}
+		if status.State == "SUCCESS" {
+			break
+		}
+		if status.State == "FAILED" || status.State == "ABORTED" || status.State == "PARTIAL" {
+			return fmt.Errorf("snapshot %s/%s finished with state %s", c.SnapshotRepository, snapshot, status.State)
+		}
+		if err := sleepContext(ctx, time.Second); err != nil {
+			log.Warnf("snapshot %s/%s goes on without esm", c.SnapshotRepository, snapshot)
+			return ErrInterrupted
+		}
+	}
+	for _, name := range names {
+		m.Metrics.AddScrolled(name, counts[name])
+	}
+
+	log.Infof("restore %s/%s on target as %s", c.SnapshotRepository, snapshot, strings.Join(restored, ","))
+	if err := m.TargetESAPI.RestoreSnapshot(c.SnapshotRepository, snapshot, indexNames, pattern, replacement); err != nil {
+		return err
+	}
+
+	// the primaries of the restored indexes are recovered from the snapshot
+	shards := 0
+	sourceIndexSettings, err := m.SourceESAPI.GetIndexSettings(indexNames)
+	if err != nil {
+		return err
+	}
+	for _, index := range *sourceIndexSettings {
+		shards += numberOfShards(index)
+	}
+
+	restoredNames := strings.Join(restored, ",")
+	for {
+		status, err := m.TargetESAPI.Recovery(restoredNames)
+		if err != nil {
+			return err
+		}
+		if m.Progress == nil {
+			restoreBar.Total = status.TotalBytes
+			restoreBar.Set64(status.RecoveredBytes)
+		}
+		if status.Shards >= shards && status.DoneShards == status.Shards {
+			break
+		}
+		if err := sleepContext(ctx, time.Second); err != nil {
+			log.Warnf("restore of %s/%s goes on without esm", c.SnapshotRepository, snapshot)
+			return ErrInterrupted
+		}
+	}
+
+	for _, name := range restored {
+		m.TargetESAPI.Refresh(name)
+		count, err := m.TargetESAPI.Count(name, "")
+		if err != nil {
+			return err
+		}
+		m.Metrics.AddBulked(name, count)
+	}
+	log.Infof("snapshot %s/%s restored", c.SnapshotRepository, snapshot)
+	return nil
+}
+
+// sleepContext waits for the duration unless ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
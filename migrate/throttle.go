@@ -0,0 +1,229 @@
+package migrate
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/cihub/seelog"
+)
+
+// RateLimiter is a token bucket shared by all bulk workers, a nil limiter
+// never blocks
+type RateLimiter struct {
+	lock   sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(perSecond int) *RateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: float64(perSecond), tokens: float64(perSecond), last: time.Now()}
+}
+
+// Wait takes n tokens from the bucket, a request larger than the bucket is
+// allowed to go into debt so that the average rate is still respected. It
+// returns the error of ctx when it is done before the tokens are available.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	tokens := l.tokens
+	l.lock.Unlock()
+
+	if tokens < 0 {
+		return sleepContext(ctx, time.Duration(-tokens/l.rate*float64(time.Second)))
+	}
+	return nil
+}
+
+// ThroughputController decides the bulk size and the number of in-flight
+// bulk requests, when adaptive mode is off both stay fixed
+type ThroughputController struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	adaptive       bool
+	bulkSize       int
+	minBulkSize    int
+	maxBulkSize    int
+	inFlight       int
+	maxInFlight    int
+	active         int
+	targetLatency  time.Duration
+	queueThreshold int
+	lastAdjust     time.Time
+	queueSize      int
+}
+
+const adaptiveBulkStep = 1024 * 1024
+const adaptiveAdjustInterval = 1 * time.Second
+
+func NewThroughputController(c *Config) *ThroughputController {
+	bulkSize := c.BulkSizeInMB * 1024 * 1024
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	t := &ThroughputController{
+		adaptive:       c.Adaptive,
+		bulkSize:       bulkSize,
+		minBulkSize:    bulkSize,
+		maxBulkSize:    bulkSize,
+		inFlight:       workers,
+		maxInFlight:    workers,
+		targetLatency:  c.BulkLatency,
+		queueThreshold: c.QueueThreshold,
+	}
+	t.cond = sync.NewCond(&t.lock)
+
+	if c.Adaptive {
+		t.minBulkSize = adaptiveBulkStep / 4
+		if c.MaxBulkSizeInMB*1024*1024 > bulkSize {
+			t.maxBulkSize = c.MaxBulkSizeInMB * 1024 * 1024
+		}
+		if t.targetLatency <= 0 {
+			t.targetLatency = 2 * time.Second
+		}
+	}
+	return t
+}
+
+// BulkSize returns the current bulk size in bytes
+func (t *ThroughputController) BulkSize() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.bulkSize
+}
+
+// Acquire blocks until a bulk request is allowed to be sent
+func (t *ThroughputController) Acquire() {
+	t.lock.Lock()
+	for t.active >= t.inFlight {
+		t.cond.Wait()
+	}
+	t.active++
+	t.lock.Unlock()
+}
+
+// Release hands back the slot taken by Acquire and feeds the result of the
+// bulk request into the controller
+func (t *ThroughputController) Release(latency time.Duration, rejected int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.active--
+	t.cond.Broadcast()
+
+	if !t.adaptive {
+		return
+	}
+
+	switch {
+	case rejected > 0:
+		t.decrease("bulk rejected")
+	case t.queueThreshold > 0 && t.queueSize > t.queueThreshold:
+		t.decrease("write queue is full")
+	case latency > t.targetLatency:
+		t.decrease("bulk latency is high")
+	case latency < t.targetLatency/2:
+		t.increase()
+	}
+}
+
+// ObserveQueue records the write thread pool queue of the target cluster
+func (t *ThroughputController) ObserveQueue(queueSize int) {
+	t.lock.Lock()
+	t.queueSize = queueSize
+	t.lock.Unlock()
+}
+
+// Backoff returns how long to wait before retrying a rejected bulk request
+func (t *ThroughputController) Backoff(attempt int) time.Duration {
+	return backoff(attempt)
+}
+
+// backoff doubles the wait on every attempt, starting at 500ms up to 30s
+func backoff(attempt int) time.Duration {
+	if attempt > 6 {
+		return 30 * time.Second
+	}
+	wait := time.Duration(1<<uint(attempt)) * 500 * time.Millisecond
+	if wait > 30*time.Second {
+		wait = 30 * time.Second
+	}
+	return wait
+}
+
+// decrease halves both the bulk size and the in-flight requests, must be
+// called with the lock held
+func (t *ThroughputController) decrease(reason string) {
+	if time.Since(t.lastAdjust) < adaptiveAdjustInterval {
+		return
+	}
+	t.lastAdjust = time.Now()
+
+	t.bulkSize = t.bulkSize / 2
+	if t.bulkSize < t.minBulkSize {
+		t.bulkSize = t.minBulkSize
+	}
+	t.inFlight = t.inFlight / 2
+	if t.inFlight < 1 {
+		t.inFlight = 1
+	}
+	log.Debugf("%s, bulk size: %d, in-flight: %d", reason, t.bulkSize, t.inFlight)
+}
+
+// increase grows the bulk size and the in-flight requests by one step, must
+// be called with the lock held
+func (t *ThroughputController) increase() {
+	if time.Since(t.lastAdjust) < adaptiveAdjustInterval {
+		return
+	}
+	t.lastAdjust = time.Now()
+
+	if t.bulkSize < t.maxBulkSize {
+		t.bulkSize += adaptiveBulkStep
+		if t.bulkSize > t.maxBulkSize {
+			t.bulkSize = t.maxBulkSize
+		}
+	}
+	if t.inFlight < t.maxInFlight {
+		t.inFlight++
+		t.cond.Broadcast()
+	}
+	log.Tracef("bulk size: %d, in-flight: %d", t.bulkSize, t.inFlight)
+}
+
+// MonitorQueue polls the write thread pool of the target cluster until the
+// done channel is closed
+func (c *Migrator) MonitorQueue(done chan struct{}) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			queueSize, err := c.TargetESAPI.GetThreadPoolQueue()
+			if err != nil {
+				log.Debug(err)
+				continue
+			}
+			c.Throttle.ObserveQueue(queueSize)
+		}
+	}
+}
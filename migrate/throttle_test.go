@@ -0,0 +1,34 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/raminhz90/esm/migrate"
+)
+
+func TestRateLimiterWaitCancel(t *testing.T) {
+	limiter := migrate.NewRateLimiter(10)
+	if err := limiter.Wait(context.Background(), 10); err != nil {
+		t.Fatalf("wait of a full bucket returned %v", err)
+	}
+
+	// the bucket is empty, 100 tokens take 10s
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := limiter.Wait(ctx, 100); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait of a cancelled context returned %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("wait of a cancelled context returned after %s", waited)
+	}
+}
+
+func TestRateLimiterNil(t *testing.T) {
+	if err := migrate.NewRateLimiter(0).Wait(context.Background(), 1000); err != nil {
+		t.Errorf("wait of a nil limiter returned %v", err)
+	}
+}
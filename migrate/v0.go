@@ -0,0 +1,991 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/cihub/seelog"
+	"github.com/raminhz90/esm/util"
+)
+
+type ESAPIV0 struct {
+	Host      string //eg: http://localhost:9200
+	Auth      *Auth  //eg: user:pass
+	HttpProxy string //eg: http://proxyIp:proxyPort
+	Compress  bool
+	Context   context.Context // cancels scroll and bulk requests
+}
+
+func (s *ESAPIV0) ClusterHealth() *ClusterHealth {
+
+	url := fmt.Sprintf("%s/_cluster/health", s.Host)
+	r, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if r != nil && r.Body != nil {
+		io.Copy(io.Discard, r.Body)
+		defer r.Body.Close()
+	}
+
+	if errs != nil {
+		return &ClusterHealth{Name: s.Host, Status: "unreachable"}
+	}
+
+	log.Debug(url)
+	log.Debug(body)
+
+	health := &ClusterHealth{}
+	err := json.Unmarshal([]byte(body), health)
+
+	if err != nil {
+		log.Error(body)
+		return &ClusterHealth{Name: s.Host, Status: "unreachable"}
+	}
+	return health
+}
+
+// WaitForStatus blocks until the indexes reach the health status or the
+// timeout expires
+func (s *ESAPIV0) WaitForStatus(indexNames string, status string, timeout time.Duration) error {
+	url := fmt.Sprintf("%s/_cluster/health/%s?wait_for_status=%s&timeout=%ds", s.Host, indexNames, status, int(timeout.Seconds()))
+	r, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if r != nil && r.Body != nil {
+		io.Copy(io.Discard, r.Body)
+		defer r.Body.Close()
+	}
+
+	if errs != nil {
+		return errs[0]
+	}
+
+	health := &ClusterHealth{}
+	err := json.Unmarshal([]byte(body), health)
+	if err != nil {
+		return errors.New(body)
+	}
+	if health.TimedOut {
+		return fmt.Errorf("%s is still %s after %s, expected %s", indexNames, health.Status, timeout, status)
+	}
+	return nil
+}
+
+func (s *ESAPIV0) Bulk(data *bytes.Buffer) (*BulkResponse, error) {
+	if data == nil || data.Len() == 0 {
+		log.Trace("data is empty, skip")
+		return nil, nil
+	}
+	data.WriteRune('\n')
+	url := fmt.Sprintf("%s/_bulk", s.Host)
+
+	body, err := DoRequest(s.Context, s.Compress, "POST", url, s.Auth, data.Bytes(), s.HttpProxy)
+	data.Reset()
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	response := BulkResponse{}
+	err = DecodeJson(body, &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Errors {
+		log.Debug(util.SubString(body, 0, 500))
+	}
+
+	return &response, nil
+}
+
+// GetIndexStats returns the primary docs count and store size per index
+func (s *ESAPIV0) GetIndexStats(indexNames string) (map[string]*IndexStats, error) {
+	url := fmt.Sprintf("%s/%s/_stats/docs,store", s.Host, indexNames)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(body)
+	}
+
+	stats := IndicesStats{}
+	err := json.Unmarshal([]byte(body), &stats)
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]*IndexStats{}
+	for name, idx := range stats.Indices {
+		result[name] = &IndexStats{Docs: idx.Primaries.Docs.Count, StoreBytes: idx.Primaries.Store.SizeInBytes}
+	}
+	return result, nil
+}
+
+// Count returns the number of documents matching the query string query
+func (s *ESAPIV0) Count(indexNames string, query string) (int, error) {
+	url := fmt.Sprintf("%s/%s/_count", s.Host, indexNames)
+
+	body := ""
+	if len(query) > 0 {
+		queryBody := map[string]interface{}{
+			"query": map[string]interface{}{
+				"query_string": map[string]interface{}{
+					"query": query,
+				},
+			},
+		}
+		data, err := json.Marshal(queryBody)
+		if err != nil {
+			return 0, err
+		}
+		body = string(data)
+	}
+
+	resp, respBody, errs := Post(url, s.Auth, body, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return 0, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return 0, errors.New(respBody)
+	}
+
+	count := CountResponse{}
+	err := json.Unmarshal([]byte(respBody), &count)
+	if err != nil {
+		return 0, err
+	}
+	return count.Count, nil
+}
+
+func (s *ESAPIV0) FieldStats(indexNames string, field string, query string) (*FieldStats, error) {
+	url := fmt.Sprintf("%s/%s/_search", s.Host, indexNames)
+
+	queryBody := map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{
+			"stats": map[string]interface{}{
+				"stats": map[string]interface{}{
+					"field": field,
+				},
+			},
+		},
+	}
+	if len(query) > 0 {
+		queryBody["query"] = map[string]interface{}{
+			"query_string": map[string]interface{}{
+				"query": query,
+			},
+		}
+	}
+	data, err := json.Marshal(queryBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, respBody, errs := Post(url, s.Auth, string(data), s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(respBody)
+	}
+
+	stats := FieldStatsResponse{}
+	err = json.Unmarshal([]byte(respBody), &stats)
+	if err != nil {
+		return nil, err
+	}
+	return &stats.Aggregations.Stats, nil
+}
+
+// GetThreadPoolQueue returns the total queue size of the bulk/write thread
+// pools across all nodes
+func (s *ESAPIV0) GetThreadPoolQueue() (int, error) {
+	url := fmt.Sprintf("%s/_nodes/stats/thread_pool", s.Host)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return 0, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return 0, errors.New(body)
+	}
+
+	stats := NodesThreadPoolStats{}
+	err := json.Unmarshal([]byte(body), &stats)
+	if err != nil {
+		return 0, err
+	}
+
+	queueSize := 0
+	for _, node := range stats.Nodes {
+		for _, name := range []string{"bulk", "write"} {
+			if pool, ok := node.ThreadPool[name]; ok {
+				queueSize += pool.Queue
+			}
+		}
+	}
+	return queueSize, nil
+}
+
+func (s *ESAPIV0) GetIndexSettings(indexNames string) (*Indexes, error) {
+
+	// get all settings
+	allSettings := &Indexes{}
+
+	url := fmt.Sprintf("%s/%s/_settings", s.Host, indexNames)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(body)
+	}
+
+	log.Debug(body)
+
+	err := json.Unmarshal([]byte(body), allSettings)
+	if err != nil {
+		panic(err)
+	}
+
+	return allSettings, nil
+}
+
+func (s *ESAPIV0) GetIndexMappings(copyAllIndexes bool, indexNames string) (string, int, *Indexes, error) {
+	url := fmt.Sprintf("%s/%s/_mapping", s.Host, indexNames)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		log.Error(errs)
+		return "", 0, nil, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return "", 0, nil, errors.New(body)
+	}
+
+	idxs := Indexes{}
+	er := json.Unmarshal([]byte(body), &idxs)
+
+	if er != nil {
+		log.Error(body)
+		return "", 0, nil, er
+	}
+
+	// remove indexes that start with . if user asked for it
+	//if copyAllIndexes == false {
+	//      for name := range idxs {
+	//              switch name[0] {
+	//              case '.':
+	//                      delete(idxs, name)
+	//              case '_':
+	//                      delete(idxs, name)
+	//
+	//
+	//                      }
+	//              }
+	//      }
+
+	// if _all indexes limit the list of indexes to only these that we kept
+	// after looking at mappings
+	if indexNames == "_all" {
+
+		var newIndexes []string
+		for name := range idxs {
+			newIndexes = append(newIndexes, name)
+		}
+		indexNames = strings.Join(newIndexes, ",")
+
+	} else if strings.Contains(indexNames, "*") || strings.Contains(indexNames, "?") {
+
+		r, err := regexp.Compile(indexNames)
+
+		//check index patterns, source resolved the ones that are no regexp
+		var newIndexes []string
+		for name := range idxs {
+			matched := err != nil || r.MatchString(name)
+			if matched {
+				newIndexes = append(newIndexes, name)
+			}
+		}
+		indexNames = strings.Join(newIndexes, ",")
+
+	}
+
+	i := 0
+	// wrap in mappings if moving from super old es
+	for name, idx := range idxs {
+		i++
+		if _, ok := idx.(map[string]interface{})["mappings"]; !ok {
+			(idxs)[name] = map[string]interface{}{
+				"mappings": idx,
+			}
+		}
+	}
+
+	return indexNames, i, &idxs, nil
+}
+
+func getEmptyIndexSettings() map[string]interface{} {
+	tempIndexSettings := map[string]interface{}{}
+	tempIndexSettings["settings"] = map[string]interface{}{}
+	tempIndexSettings["settings"].(map[string]interface{})["index"] = map[string]interface{}{}
+	return tempIndexSettings
+}
+
+// flattenIndexSettings turns user provided settings into index level
+// settings, keys may be flat like "index.refresh_interval" or nested under
+// "settings" and "index"
+func flattenIndexSettings(settings map[string]interface{}) map[string]interface{} {
+	index := map[string]interface{}{}
+	for k, v := range settings {
+		if nested, ok := v.(map[string]interface{}); ok && (k == "settings" || k == "index") {
+			for nk, nv := range flattenIndexSettings(nested) {
+				index[nk] = nv
+			}
+			continue
+		}
+		index[strings.TrimPrefix(k, "index.")] = v
+	}
+	return index
+}
+
+func cleanSettings(settings map[string]interface{}) {
+	//clean up settings
+	delete(settings["settings"].(map[string]interface{})["index"].(map[string]interface{}), "creation_date")
+	delete(settings["settings"].(map[string]interface{})["index"].(map[string]interface{}), "uuid")
+	delete(settings["settings"].(map[string]interface{})["index"].(map[string]interface{}), "version")
+	delete(settings["settings"].(map[string]interface{})["index"].(map[string]interface{}), "provided_name")
+}
+
+func (s *ESAPIV0) UpdateIndexSettings(name string, settings map[string]interface{}) error {
+
+	log.Debug("update index: ", name, settings)
+	cleanSettings(settings)
+	url := fmt.Sprintf("%s/%s/_settings", s.Host, name)
+
+	if _, ok := settings["settings"].(map[string]interface{})["index"]; ok {
+		if set, ok := settings["settings"].(map[string]interface{})["index"].(map[string]interface{})["analysis"]; ok {
+			log.Debug("update static index settings: ", name)
+			staticIndexSettings := getEmptyIndexSettings()
+			staticIndexSettings["settings"].(map[string]interface{})["index"].(map[string]interface{})["analysis"] = set
+			Post(fmt.Sprintf("%s/%s/_close", s.Host, name), s.Auth, "", s.HttpProxy)
+			body := bytes.Buffer{}
+			enc := json.NewEncoder(&body)
+			enc.Encode(staticIndexSettings)
+			bodyStr, err := Request("PUT", url, s.Auth, &body, s.HttpProxy)
+			if err != nil {
+				log.Error(bodyStr, err)
+				panic(err)
+			}
+			delete(settings["settings"].(map[string]interface{})["index"].(map[string]interface{}), "analysis")
+			Post(fmt.Sprintf("%s/%s/_open", s.Host, name), s.Auth, "", s.HttpProxy)
+		}
+	}
+
+	log.Debug("update dynamic index settings: ", name)
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(settings)
+	_, err := Request("PUT", url, s.Auth, &body, s.HttpProxy)
+
+	return err
+}
+
+func (s *ESAPIV0) UpdateIndexMapping(indexName string, settings map[string]interface{}) error {
+
+	log.Debug("start update mapping: ", indexName, settings)
+
+	for name, mapping := range settings {
+
+		log.Debug("start update mapping: ", indexName, name, mapping)
+
+		url := fmt.Sprintf("%s/%s/%s/_mapping", s.Host, indexName, name)
+
+		body := bytes.Buffer{}
+		enc := json.NewEncoder(&body)
+		enc.Encode(mapping)
+		res, err := Request("POST", url, s.Auth, &body, s.HttpProxy)
+		if err != nil {
+			log.Error(url)
+			log.Error(body.String())
+			log.Error(err, res)
+			panic(err)
+		}
+	}
+	return nil
+}
+
+func (s *ESAPIV0) DeleteIndex(name string) (err error) {
+
+	log.Debug("start delete index: ", name)
+
+	url := fmt.Sprintf("%s/%s", s.Host, name)
+
+	body, err := Request("DELETE", url, s.Auth, nil, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to delete index %s: %v", name, err)
+	}
+
+	ack := AcknowledgedResponse{}
+	if err := json.Unmarshal([]byte(body), &ack); err != nil || !ack.Acknowledged {
+		return fmt.Errorf("delete of index %s was not acknowledged: %s", name, body)
+	}
+
+	// make sure the index is gone before it gets recreated
+	exists, err := s.indexExists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("index %s still exists after delete", name)
+	}
+
+	log.Debug("delete index: ", name)
+
+	return nil
+}
+
+func (s *ESAPIV0) indexExists(name string) (bool, error) {
+	url := fmt.Sprintf("%s/%s", s.Host, name)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return false, errs[0]
+	}
+
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	}
+	return false, errors.New(body)
+}
+
+// Snapshot takes a snapshot of the indexes into an existing repository and
+// waits for it to complete
+func (s *ESAPIV0) Snapshot(repository string, snapshot string, indexNames string) error {
+
+	log.Debugf("start snapshot %s/%s of: %s", repository, snapshot, indexNames)
+
+	url := fmt.Sprintf("%s/_snapshot/%s/%s?wait_for_completion=true", s.Host, repository, snapshot)
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]interface{}{
+		"indices":              indexNames,
+		"include_global_state": false,
+	})
+
+	resp, err := Request("PUT", url, s.Auth, &body, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot %s: %v", indexNames, err)
+	}
+
+	result := SnapshotResponse{}
+	if err := json.Unmarshal([]byte(resp), &result); err != nil {
+		return err
+	}
+	if result.Snapshot.State != "SUCCESS" {
+		return fmt.Errorf("snapshot %s/%s finished with state %s", repository, snapshot, result.Snapshot.State)
+	}
+	return nil
+}
+
+// CreateRepository registers a snapshot repository, ie: {"type":"fs","settings":{"location":"/mnt/esm"}}
+func (s *ESAPIV0) CreateRepository(name string, settings map[string]interface{}) error {
+	log.Debugf("register snapshot repository %s: %v", name, settings)
+
+	url := fmt.Sprintf("%s/_snapshot/%s", s.Host, name)
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(settings)
+
+	_, err := Request("PUT", url, s.Auth, &body, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to register snapshot repository %s: %v", name, err)
+	}
+	return nil
+}
+
+// StartSnapshot starts a snapshot of the indexes without waiting for it,
+// see SnapshotStatus
+func (s *ESAPIV0) StartSnapshot(repository string, snapshot string, indexNames string) error {
+	log.Debugf("start snapshot %s/%s of: %s", repository, snapshot, indexNames)
+
+	url := fmt.Sprintf("%s/_snapshot/%s/%s", s.Host, repository, snapshot)
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]interface{}{
+		"indices":              indexNames,
+		"include_global_state": false,
+	})
+
+	_, err := Request("PUT", url, s.Auth, &body, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot %s: %v", indexNames, err)
+	}
+	return nil
+}
+
+// SnapshotStatus returns the state and the bytes processed of a snapshot
+func (s *ESAPIV0) SnapshotStatus(repository string, snapshot string) (*SnapshotStatus, error) {
+	url := fmt.Sprintf("%s/_snapshot/%s/%s/_status", s.Host, repository, snapshot)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(body)
+	}
+
+	result := SnapshotStatusResponse{}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		return nil, err
+	}
+	if len(result.Snapshots) == 0 {
+		return nil, fmt.Errorf("snapshot %s/%s not found", repository, snapshot)
+	}
+
+	// the stats are nested in total and processed since 7.x
+	stats := result.Snapshots[0].Stats
+	status := &SnapshotStatus{
+		State:          result.Snapshots[0].State,
+		TotalBytes:     stats.TotalSizeInBytes + stats.Total.SizeInBytes,
+		ProcessedBytes: stats.ProcessedSizeInBytes + stats.Processed.SizeInBytes,
+	}
+	return status, nil
+}
+
+// RestoreSnapshot starts restoring the indexes of a snapshot without waiting
+// for it, see Recovery. Indexes are renamed by the java regex renamePattern
+// if set.
+func (s *ESAPIV0) RestoreSnapshot(repository string, snapshot string, indexNames string, renamePattern string, renameReplacement string) error {
+	log.Debugf("restore snapshot %s/%s of: %s", repository, snapshot, indexNames)
+
+	url := fmt.Sprintf("%s/_snapshot/%s/%s/_restore", s.Host, repository, snapshot)
+
+	request := map[string]interface{}{
+		"indices":              indexNames,
+		"include_global_state": false,
+	}
+	if len(renamePattern) > 0 {
+		request["rename_pattern"] = renamePattern
+		request["rename_replacement"] = renameReplacement
+	}
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(request)
+
+	_, err := Request("POST", url, s.Auth, &body, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot %s/%s: %v", repository, snapshot, err)
+	}
+	return nil
+}
+
+// Recovery sums up the recovery of the primary shards of the indexes
+func (s *ESAPIV0) Recovery(indexNames string) (*RecoveryStatus, error) {
+	url := fmt.Sprintf("%s/%s/_recovery", s.Host, indexNames)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	// the indexes don't exist until the restore started
+	status := &RecoveryStatus{}
+	if resp.StatusCode == 404 {
+		return status, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, errors.New(body)
+	}
+
+	result := RecoveryResponse{}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		return nil, err
+	}
+	for _, index := range result {
+		for _, shard := range index.Shards {
+			if !shard.Primary {
+				continue
+			}
+			status.Shards++
+			if shard.Stage == "DONE" {
+				status.DoneShards++
+			}
+			status.TotalBytes += shard.Index.Size.TotalInBytes
+			status.RecoveredBytes += shard.Index.Size.RecoveredInBytes
+		}
+	}
+	return status, nil
+}
+
+// StartReindex submits a _reindex as background task and returns the task
+// id, see TaskStatus
+func (s *ESAPIV0) StartReindex(request map[string]interface{}) (string, error) {
+	url := fmt.Sprintf("%s/_reindex?wait_for_completion=false", s.Host)
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(request)
+
+	resp, err := Request("POST", url, s.Auth, &body, s.HttpProxy)
+	if err != nil {
+		return "", fmt.Errorf("failed to start reindex: %v", err)
+	}
+
+	result := struct {
+		Task string `json:"task"`
+	}{}
+	if err := json.Unmarshal([]byte(resp), &result); err != nil {
+		return "", err
+	}
+	if len(result.Task) == 0 {
+		return "", fmt.Errorf("no task in reindex response: %s", util.SubString(resp, 0, 500))
+	}
+	return result.Task, nil
+}
+
+// TaskStatus returns the progress of a reindex task, and its result once it
+// is completed
+func (s *ESAPIV0) TaskStatus(taskId string) (*TaskStatus, error) {
+	url := fmt.Sprintf("%s/_tasks/%s", s.Host, taskId)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(body)
+	}
+
+	status := &TaskStatus{}
+	if err := json.Unmarshal([]byte(body), status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// CancelTask cancels a running task, the work it did is kept
+func (s *ESAPIV0) CancelTask(taskId string) error {
+	url := fmt.Sprintf("%s/_tasks/%s/_cancel", s.Host, taskId)
+	_, err := Request("POST", url, s.Auth, &bytes.Buffer{}, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to cancel task %s: %v", taskId, err)
+	}
+	return nil
+}
+
+// Reindex copies all documents of source into dest and waits for it to
+// complete
+func (s *ESAPIV0) Reindex(source string, dest string) error {
+
+	log.Debugf("start reindex %s to %s", source, dest)
+
+	url := fmt.Sprintf("%s/_reindex?wait_for_completion=true&refresh=true", s.Host)
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]interface{}{
+		"source": map[string]interface{}{"index": source},
+		"dest":   map[string]interface{}{"index": dest},
+	})
+
+	resp, err := Request("POST", url, s.Auth, &body, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to reindex %s to %s: %v", source, dest, err)
+	}
+
+	result := ReindexResponse{}
+	if err := json.Unmarshal([]byte(resp), &result); err != nil {
+		return err
+	}
+	if result.TimedOut || len(result.Failures) > 0 {
+		return fmt.Errorf("reindex %s to %s failed: %s", source, dest, util.SubString(resp, 0, 500))
+	}
+	return nil
+}
+
+func (s *ESAPIV0) CloseIndex(name string) error {
+	log.Debug("close index: ", name)
+
+	url := fmt.Sprintf("%s/%s/_close", s.Host, name)
+	_, err := Request("POST", url, s.Auth, &bytes.Buffer{}, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to close index %s: %v", name, err)
+	}
+	return nil
+}
+
+// GetAliases returns the indexes the alias points to
+func (s *ESAPIV0) GetAliases(alias string) ([]string, error) {
+	url := fmt.Sprintf("%s/_alias/%s", s.Host, alias)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	// the alias doesn't exist yet
+	if resp.StatusCode == 404 {
+		return []string{}, nil
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(body)
+	}
+
+	aliases := AliasesResponse{}
+	err := json.Unmarshal([]byte(body), &aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	indexNames := []string{}
+	for name, idx := range aliases {
+		if _, ok := idx.Aliases[alias]; ok {
+			indexNames = append(indexNames, name)
+		}
+	}
+	sort.Strings(indexNames)
+	return indexNames, nil
+}
+
+// GetIndexAliases returns the aliases of the indexes with their filters and
+// routings
+func (s *ESAPIV0) GetIndexAliases(indexNames string) (AliasesResponse, error) {
+	url := fmt.Sprintf("%s/%s/_alias", s.Host, indexNames)
+	resp, body, errs := Get(url, s.Auth, s.HttpProxy)
+
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	if errs != nil {
+		return nil, errs[0]
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, errors.New(body)
+	}
+
+	aliases := AliasesResponse{}
+	if err := json.Unmarshal([]byte(body), &aliases); err != nil {
+		return nil, err
+	}
+	return aliases, nil
+}
+
+// UpdateAliases applies add/remove alias actions atomically
+func (s *ESAPIV0) UpdateAliases(actions []map[string]interface{}) error {
+	log.Debug("update aliases: ", actions)
+
+	url := fmt.Sprintf("%s/_aliases", s.Host)
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]interface{}{"actions": actions})
+
+	resp, err := Request("POST", url, s.Auth, &body, s.HttpProxy)
+	if err != nil {
+		return fmt.Errorf("failed to update aliases: %v", err)
+	}
+
+	ack := AcknowledgedResponse{}
+	if err := json.Unmarshal([]byte(resp), &ack); err != nil || !ack.Acknowledged {
+		return fmt.Errorf("update of aliases was not acknowledged: %s", resp)
+	}
+	return nil
+}
+
+func (s *ESAPIV0) CreateIndex(name string, settings map[string]interface{}) (err error) {
+	cleanSettings(settings)
+
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(settings)
+	log.Debug("start create index: ", name, settings)
+
+	url := fmt.Sprintf("%s/%s", s.Host, name)
+
+	resp, err := Request("PUT", url, s.Auth, &body, s.HttpProxy)
+	log.Debugf("response: %s", resp)
+
+	return err
+}
+
+func (s *ESAPIV0) Refresh(name string) (err error) {
+
+	log.Debug("refresh index: ", name)
+
+	url := fmt.Sprintf("%s/%s/_refresh", s.Host, name)
+
+	resp, _, _ := Post(url, s.Auth, "", s.HttpProxy)
+	if resp != nil && resp.Body != nil {
+		io.Copy(io.Discard, resp.Body)
+		defer resp.Body.Close()
+	}
+
+	return nil
+}
+
+func (s *ESAPIV0) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string, preference string) (scroll interface{}, err error) {
+
+	// curl -XGET 'http://es-0.9:9200/_search?search_type=scan&scroll=10m&size=50'
+	url := fmt.Sprintf("%s/%s/_search?search_type=scan&scroll=%s&size=%d", s.Host, indexNames, scrollTime, docBufferCount)
+	if len(preference) > 0 {
+		url += "&preference=" + preference
+	}
+
+	var jsonBody []byte
+	if len(query) > 0 || len(fields) > 0 {
+		queryBody := map[string]interface{}{}
+		if len(fields) > 0 {
+			if !strings.Contains(fields, ",") {
+				queryBody["_source"] = fields
+			} else {
+				queryBody["_source"] = strings.Split(fields, ",")
+			}
+		}
+
+		if len(query) > 0 {
+			queryBody["query"] = map[string]interface{}{}
+			queryBody["query"].(map[string]interface{})["query_string"] = map[string]interface{}{}
+			queryBody["query"].(map[string]interface{})["query_string"].(map[string]interface{})["query"] = query
+		}
+
+		jsonBody, err = json.Marshal(queryBody)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+
+	}
+	//resp, body, errs := Post(url, s.Auth,jsonBody,s.HttpProxy)
+	body, err := DoRequest(s.Context, s.Compress, "POST", url, s.Auth, jsonBody, s.HttpProxy)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	scroll = &Scroll{}
+	err = DecodeJson(body, scroll)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return scroll, err
+}
+
+func (s *ESAPIV0) NextScroll(scrollTime string, scrollId string) (interface{}, error) {
+	//  curl -XGET 'http://es-0.9:9200/_search/scroll?scroll=5m'
+	id := bytes.NewBufferString(scrollId)
+	url := fmt.Sprintf("%s/_search/scroll?scroll=%s&scroll_id=%s", s.Host, scrollTime, id)
+	body, err := DoRequest(s.Context, s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
+
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	// decode elasticsearch scroll response
+	scroll := &Scroll{}
+	err = DecodeJson(body, &scroll)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	return scroll, nil
+}
+
+// ClearScroll frees the search context of the scroll on source
+func (s *ESAPIV0) ClearScroll(scrollId string) error {
+	url := fmt.Sprintf("%s/_search/scroll", s.Host)
+	_, err := Request("DELETE", url, s.Auth, bytes.NewBufferString(scrollId), s.HttpProxy)
+	return err
+}
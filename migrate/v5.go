@@ -1,4 +1,4 @@
-package main
+package migrate
 
 import (
 	"bytes"
@@ -17,8 +17,8 @@ func (s *ESAPIV5) ClusterHealth() *ClusterHealth {
 	return s.ESAPIV0.ClusterHealth()
 }
 
-func (s *ESAPIV5) Bulk(data *bytes.Buffer) {
-	s.ESAPIV0.Bulk(data)
+func (s *ESAPIV5) Bulk(data *bytes.Buffer) (*BulkResponse, error) {
+	return s.ESAPIV0.Bulk(data)
 }
 
 func (s *ESAPIV5) GetIndexSettings(indexNames string) (*Indexes, error) {
@@ -49,8 +49,11 @@ func (s *ESAPIV5) Refresh(name string) (err error) {
 	return s.ESAPIV0.Refresh(name)
 }
 
-func (s *ESAPIV5) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string) (scroll interface{}, err error) {
+func (s *ESAPIV5) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string, preference string) (scroll interface{}, err error) {
 	url := fmt.Sprintf("%s/%s/_search?scroll=%s&size=%d", s.Host, indexNames, scrollTime, docBufferCount)
+	if len(preference) > 0 {
+		url += "&preference=" + preference
+	}
 
 	var jsonBody []byte
 	if len(query) > 0 || maxSlicedCount > 0 || len(fields) > 0 {
@@ -83,7 +86,7 @@ func (s *ESAPIV5) NewScroll(indexNames string, scrollTime string, docBufferCount
 		}
 	}
 
-	body, err := DoRequest(s.Compress, "POST", url, s.Auth, jsonBody, s.HttpProxy)
+	body, err := DoRequest(s.Context, s.Compress, "POST", url, s.Auth, jsonBody, s.HttpProxy)
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -104,7 +107,7 @@ func (s *ESAPIV5) NextScroll(scrollTime string, scrollId string) (interface{}, e
 
 	url := fmt.Sprintf("%s/_search/scroll?scroll=%s&scroll_id=%s", s.Host, scrollTime, id)
 
-	body, err := DoRequest(s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
+	body, err := DoRequest(s.Context, s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -120,3 +123,12 @@ func (s *ESAPIV5) NextScroll(scrollTime string, scrollId string) (interface{}, e
 
 	return scroll, nil
 }
+
+func (s *ESAPIV5) ClearScroll(scrollId string) error {
+	url := fmt.Sprintf("%s/_search/scroll", s.Host)
+	body := bytes.Buffer{}
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]interface{}{"scroll_id": []string{scrollId}})
+	_, err := Request("DELETE", url, s.Auth, &body, s.HttpProxy)
+	return err
+}
@@ -1,4 +1,4 @@
-package main
+package migrate
 
 import (
 	"bytes"
@@ -17,8 +17,11 @@ type ESAPIV6 struct {
 	ESAPIV5
 }
 
-func (s *ESAPIV6) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string) (scroll interface{}, err error) {
+func (s *ESAPIV6) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string, preference string) (scroll interface{}, err error) {
 	url := fmt.Sprintf("%s/%s/_search?scroll=%s&size=%d", s.Host, indexNames, scrollTime, docBufferCount)
+	if len(preference) > 0 {
+		url += "&preference=" + preference
+	}
 
 	var jsonBody []byte
 	if len(query) > 0 || maxSlicedCount > 0 || len(fields) > 0 {
@@ -52,7 +55,7 @@ func (s *ESAPIV6) NewScroll(indexNames string, scrollTime string, docBufferCount
 		}
 	}
 
-	body, err := DoRequest(s.Compress, "POST", url, s.Auth, jsonBody, s.HttpProxy)
+	body, err := DoRequest(s.Context, s.Compress, "POST", url, s.Auth, jsonBody, s.HttpProxy)
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -72,11 +75,14 @@ func (s *ESAPIV6) NextScroll(scrollTime string, scrollId string) (interface{}, e
 	id := bytes.NewBufferString(scrollId)
 
 	url := fmt.Sprintf("%s/_search/scroll?scroll=%s&scroll_id=%s", s.Host, scrollTime, id)
-	body, _ := DoRequest(s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
+	body, err := DoRequest(s.Context, s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
+	if err != nil {
+		return nil, err
+	}
 
 	// decode elasticsearch scroll response
 	scroll := &Scroll{}
-	err := DecodeJson(body, &scroll)
+	err = DecodeJson(body, &scroll)
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -131,12 +137,12 @@ func (s *ESAPIV6) GetIndexMappings(copyAllIndexes bool, indexNames string) (stri
 
 	} else if strings.Contains(indexNames, "*") || strings.Contains(indexNames, "?") {
 
-		r, _ := regexp.Compile(indexNames)
+		r, err := regexp.Compile(indexNames)
 
-		//check index patterns
+		//check index patterns, source resolved the ones that are no regexp
 		var newIndexes []string
 		for name := range idxs {
-			matched := r.MatchString(name)
+			matched := err != nil || r.MatchString(name)
 			if matched {
 				newIndexes = append(newIndexes, name)
 			}
@@ -1,4 +1,4 @@
-package main
+package migrate
 
 import (
 	"bytes"
@@ -16,8 +16,11 @@ type ESAPIV7 struct {
 	ESAPIV6
 }
 
-func (s *ESAPIV7) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string) (scroll interface{}, err error) {
+func (s *ESAPIV7) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string, preference string) (scroll interface{}, err error) {
 	url := fmt.Sprintf("%s/%s/_search?scroll=%s&size=%d", s.Host, indexNames, scrollTime, docBufferCount)
+	if len(preference) > 0 {
+		url += "&preference=" + preference
+	}
 
 	jsonBody := ""
 	if len(query) > 0 || maxSlicedCount > 0 || len(fields) > 0 {
@@ -90,7 +93,7 @@ func (s *ESAPIV7) NextScroll(scrollTime string, scrollId string) (interface{}, e
 	id := bytes.NewBufferString(scrollId)
 
 	url := fmt.Sprintf("%s/_search/scroll?scroll=%s&scroll_id=%s", s.Host, scrollTime, id)
-	body, err := DoRequest(s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
+	body, err := DoRequest(s.Context, s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
 
 	if err != nil {
 		//log.Error(errs)
@@ -153,12 +156,12 @@ func (s *ESAPIV7) GetIndexMappings(copyAllIndexes bool, indexNames string) (stri
 
 	} else if strings.Contains(indexNames, "*") || strings.Contains(indexNames, "?") {
 
-		r, _ := regexp.Compile(indexNames)
+		r, err := regexp.Compile(indexNames)
 
-		//check index patterns
+		//check index patterns, source resolved the ones that are no regexp
 		var newIndexes []string
 		for name := range idxs {
-			matched := r.MatchString(name)
+			matched := err != nil || r.MatchString(name)
 			if matched {
 				newIndexes = append(newIndexes, name)
 			}
@@ -171,7 +174,7 @@ func (s *ESAPIV7) GetIndexMappings(copyAllIndexes bool, indexNames string) (stri
 	// wrap in mappings if moving from super old es
 	for name, idx := range idxs {
 		i++
-		fmt.Println(name)
+		log.Trace(name)
 		if _, ok := idx.(map[string]interface{})["mappings"]; !ok {
 			(idxs)[name] = map[string]interface{}{
 				"mappings": idx,
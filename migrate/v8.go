@@ -1,4 +1,4 @@
-package main
+package migrate
 
 import (
 	"bytes"
@@ -19,9 +19,12 @@ type ESAPIV8 struct {
 }
 
 // NewScroll creates a  scroll in Elasticsearch API version 8.
-func (s *ESAPIV8) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string) (scroll interface{}, err error) {
+func (s *ESAPIV8) NewScroll(indexNames string, scrollTime string, docBufferCount int, query string, slicedId, maxSlicedCount int, fields string, preference string) (scroll interface{}, err error) {
 	// Build the URL for the Elasticsearch search API with scroll.
 	url := fmt.Sprintf("%s/%s/_search?scroll=%s&size=%d", s.Host, indexNames, scrollTime, docBufferCount)
+	if len(preference) > 0 {
+		url += "&preference=" + preference
+	}
 
 	// Create the body of the request if necessary.
 	jsonBody := createJSONBody(query, maxSlicedCount, fields, slicedId)
@@ -102,7 +105,7 @@ func (s *ESAPIV8) NextScroll(scrollTime string, scrollId string) (interface{}, e
 	id := bytes.NewBufferString(scrollId)
 
 	url := fmt.Sprintf("%s/_search/scroll?scroll=%s&scroll_id=%s", s.Host, scrollTime, id)
-	body, err := DoRequest(s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
+	body, err := DoRequest(s.Context, s.Compress, "GET", url, s.Auth, nil, s.HttpProxy)
 
 	if err != nil {
 		//log.Error(errs)
@@ -165,12 +168,12 @@ func (s *ESAPIV8) GetIndexMappings(copyAllIndexes bool, indexNames string) (stri
 
 	} else if strings.Contains(indexNames, "*") || strings.Contains(indexNames, "?") {
 
-		r, _ := regexp.Compile(indexNames)
+		r, err := regexp.Compile(indexNames)
 
-		//check index patterns
+		//check index patterns, source resolved the ones that are no regexp
 		var newIndexes []string
 		for name := range idxs {
-			matched := r.MatchString(name)
+			matched := err != nil || r.MatchString(name)
 			if matched {
 				newIndexes = append(newIndexes, name)
 			}
@@ -183,7 +186,7 @@ func (s *ESAPIV8) GetIndexMappings(copyAllIndexes bool, indexNames string) (stri
 	// wrap in mappings if moving from super old es
 	for name, idx := range idxs {
 		i++
-		fmt.Println(name)
+		log.Trace(name)
 		if _, ok := idx.(map[string]interface{})["mappings"]; !ok {
 			(idxs)[name] = map[string]interface{}{
 				"mappings": idx,